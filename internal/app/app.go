@@ -2,47 +2,166 @@ package app
 
 import (
 	"context"
-	"log"
-	"regexp"
 	"time"
 
 	"github.com/atton16/go-pair-dump/internal/services"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func GetSymbols(ctx context.Context) *[]string {
-	var config = services.GetConfig()
+// GetSymbols returns the exchangeInfo metadata of every symbol.
+func GetSymbols(ctx context.Context) ([]services.BinanceSymbol, error) {
 	var binance = services.GetBinance()
-	data, err := binance.ExchangeInfo()
+	data, err := binance.ExchangeInfo(ctx)
 	if err != nil {
-		NotifyError(ctx, AppGetSymbols, err)
-		log.Fatalf("error: %v", err)
+		return nil, upstreamError(ctx, AppGetSymbols, err)
 	}
 	// log.Printf("exchangeInfo: %+v\n", data)
-	var filterPattern = regexp.MustCompile(config.Binance.FilterPattern)
-	var symbols []string
-	for _, symbol := range data.Symbols {
-		if filterPattern.MatchString(symbol.Symbol) {
-			symbols = append(symbols, symbol.Symbol)
+	return data.Symbols, nil
+}
+
+// FilterSymbols returns the symbols picked by the binance.filterPattern and
+// binance.symbols selector.
+func FilterSymbols(symbols []services.BinanceSymbol) []services.BinanceSymbol {
+	var config = services.GetConfig()
+	var selector = NewSymbolSelector(config)
+	var filtered []services.BinanceSymbol
+	for _, symbol := range symbols {
+		if selector.Select(symbol) {
+			filtered = append(filtered, symbol)
 		}
 	}
-	return &symbols
+	return filtered
 }
 
-func GetKlines(ctx context.Context, symbol string, interval services.BinanceKlineInterval, limit int) []services.BinanceKline {
-	var binance = services.GetBinance()
+// SymbolNames returns the names of symbols.
+func SymbolNames(symbols []services.BinanceSymbol) []string {
+	var names []string
+	for _, symbol := range symbols {
+		names = append(names, symbol.Symbol)
+	}
+	return names
+}
+
+// upstreamError tags a Binance error, cancellations are passed through as is.
+func upstreamError(ctx context.Context, scope PairdumpScope, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return NewScopeError(scope, ExitUpstream, err)
+}
+
+// storageError tags a storage error, cancellations are passed through as is.
+func storageError(ctx context.Context, scope PairdumpScope, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return NewScopeError(scope, ExitStorage, err)
+}
+
+// KlinesFetcher fetches klines from Binance, implemented by
+// services.Binance.
+type KlinesFetcher interface {
+	Klines(ctx context.Context, symbol string, interval services.BinanceKlineInterval, opts ...*services.BinanceKlinesOptions) ([]services.BinanceKline, error)
+}
+
+func GetKlines(ctx context.Context, fetcher KlinesFetcher, symbol string, interval services.BinanceKlineInterval, limit int) ([]services.BinanceKline, error) {
 	opts := services.BinanceKlinesOptions{
 		Limit: &limit,
 	}
-	data, err := binance.Klines(symbol, interval, &opts)
+	data, err := fetcher.Klines(ctx, symbol, interval, &opts)
+	if err != nil {
+		return nil, upstreamError(ctx, AppGetKlines, err)
+	}
+	return data, nil
+}
+
+// GetKlinesFrom fetches up to limit klines with openTime >= startTime.
+func GetKlinesFrom(ctx context.Context, fetcher KlinesFetcher, symbol string, interval services.BinanceKlineInterval, limit int, startTime time.Time) ([]services.BinanceKline, error) {
+	startTimeMs := startTime.UnixMilli()
+	opts := services.BinanceKlinesOptions{
+		StartTime: &startTimeMs,
+		Limit:     &limit,
+	}
+	data, err := fetcher.Klines(ctx, symbol, interval, &opts)
+	if err != nil {
+		return nil, upstreamError(ctx, AppGetKlines, err)
+	}
+	return data, nil
+}
+
+// BackfillKlines walks klines forward from since in limit-sized pages until
+// the last closed kline, calling fn with every page. A zero since starts from
+// the symbol listing.
+func BackfillKlines(ctx context.Context, fetcher KlinesFetcher, symbol string, interval services.BinanceKlineInterval, limit int, since time.Time, fn func(klines []services.BinanceKline) error) error {
+	startTime := since
+	if startTime.IsZero() {
+		startTime = time.UnixMilli(0)
+	}
+	for {
+		klines, err := GetKlinesFrom(ctx, fetcher, symbol, interval, limit, startTime)
+		if err != nil {
+			return err
+		}
+		fetched := len(klines)
+		klines = KlinesWithoutUnclosedKline(klines)
+		if len(klines) > 0 {
+			if err := fn(klines); err != nil {
+				return err
+			}
+			startTime = klines[len(klines)-1].OpenTime.Add(time.Millisecond)
+		}
+		if fetched < limit || len(klines) < fetched {
+			return nil
+		}
+	}
+}
+
+// DumpKlines fetches the closed klines of one symbol and interval and passes
+// them to fn page by page. Incremental mode resumes from the last stored
+// openTime, backfill mode walks the full history from since, otherwise only
+// the latest page is fetched.
+func DumpKlines(ctx context.Context, config *services.Config, fetcher KlinesFetcher, sink Sink, symbol string, ki services.KlinesInterval, fn func(klines []services.BinanceKline) error) error {
+	since, err := ParseSince(ki.Since)
 	if err != nil {
-		NotifyError(ctx, AppGetKlines, err)
-		log.Fatalf("error: %v", err)
+		return NewScopeError(AppConfig, ExitConfig, err)
 	}
-	return data
+	if config.Binance.Klines.Incremental {
+		startTime := since
+		lastOpenTime, err := sink.LastOpenTime(ctx, symbol, ki.Interval)
+		if err != nil {
+			return storageError(ctx, AppLastOpenTime, err)
+		}
+		if lastOpenTime != nil {
+			startTime = lastOpenTime.Add(time.Millisecond)
+		}
+		return BackfillKlines(ctx, fetcher, symbol, ki.Interval, ki.Limit, startTime, fn)
+	}
+	if config.Binance.Klines.Backfill {
+		return BackfillKlines(ctx, fetcher, symbol, ki.Interval, ki.Limit, since, fn)
+	}
+	klines, err := GetKlines(ctx, fetcher, symbol, ki.Interval, ki.Limit)
+	if err != nil {
+		return err
+	}
+	klines = KlinesWithoutUnclosedKline(klines)
+	if len(klines) > 0 {
+		return fn(klines)
+	}
+	return nil
+}
+
+// ParseSince parses a YYYY-MM-DD backfill start date, blank means listing.
+func ParseSince(since string) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02", since, time.UTC)
 }
 
 func KlinesWithoutUnclosedKline(klines []services.BinanceKline) []services.BinanceKline {
+	if len(klines) == 0 {
+		return klines
+	}
 	outKlines := klines
 	lastIndex := len(klines) - 1
 	lastKline := klines[lastIndex]
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+)
+
+// ExitCode is the process exit status, orchestrators may branch on it.
+type ExitCode int
+
+const (
+	ExitOK        ExitCode = 0
+	ExitError     ExitCode = 1
+	ExitConfig    ExitCode = 2
+	ExitUpstream  ExitCode = 3
+	ExitStorage   ExitCode = 4
+	ExitPartial   ExitCode = 5
+	ExitCancelled ExitCode = 130
+)
+
+// ScopeError tags an error with the scope it happened in, used for
+// notifications, and the exit code it maps to.
+type ScopeError struct {
+	Scope PairdumpScope
+	Code  ExitCode
+	Err   error
+}
+
+func (e *ScopeError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Scope, e.Err)
+}
+
+func (e *ScopeError) Unwrap() error {
+	return e.Err
+}
+
+func NewScopeError(scope PairdumpScope, code ExitCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ScopeError{Scope: scope, Code: code, Err: err}
+}
+
+// SymbolError tags an error with the symbol and interval being dumped.
+type SymbolError struct {
+	Symbol   string
+	Interval string
+	Err      error
+}
+
+func (e *SymbolError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Symbol, e.Interval, e.Err)
+}
+
+func (e *SymbolError) Unwrap() error {
+	return e.Err
+}
+
+// DocumentError is the write error of a single symbol or kline document.
+type DocumentError struct {
+	Symbol   string     `json:"symbol"`
+	Interval string     `json:"interval,omitempty"`
+	OpenTime *time.Time `json:"openTime,omitempty"`
+	Code     int        `json:"code"`
+	Message  string     `json:"message"`
+}
+
+func (e DocumentError) String() string {
+	doc := e.Symbol
+	if e.Interval != "" {
+		doc += " " + e.Interval
+	}
+	if e.OpenTime != nil {
+		doc += " " + e.OpenTime.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%s: (%d) %s", doc, e.Code, e.Message)
+}
+
+// WriteError lists the documents a bulk write failed for, with unordered
+// writes the other documents were still written. Err is set for errors not
+// tied to a document, e.g. write concern errors.
+type WriteError struct {
+	Documents []DocumentError
+	Err       error
+}
+
+func (e *WriteError) Error() string {
+	if len(e.Documents) == 0 {
+		return fmt.Sprintf("write failed: %v", e.Err)
+	}
+	msg := fmt.Sprintf("%d documents failed to write, first %s", len(e.Documents), e.Documents[0])
+	if e.Err != nil {
+		msg += fmt.Sprintf(", %v", e.Err)
+	}
+	return msg
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// DocumentErrorsOf returns the failed documents of err, or nil if it is not
+// a WriteError.
+func DocumentErrorsOf(err error) []DocumentError {
+	var writeErr *WriteError
+	if errors.As(err, &writeErr) {
+		return writeErr.Documents
+	}
+	return nil
+}
+
+// ExitCodeOf maps err to the exit code of the process.
+func ExitCodeOf(err error) ExitCode {
+	if err == nil {
+		return ExitOK
+	}
+	if errors.Is(err, context.Canceled) {
+		return ExitCancelled
+	}
+	var scopeErr *ScopeError
+	if errors.As(err, &scopeErr) {
+		return scopeErr.Code
+	}
+	return ExitError
+}
+
+// IsSymbolOnly reports whether err only concerns the symbol it happened
+// for, i.e. a Binance 4xx client error such as -1121 invalid symbol. Rate
+// limits and IP bans concern every request from the IP.
+func IsSymbolOnly(err error) bool {
+	var clientErr *services.BinanceClientError
+	return errors.As(err, &clientErr)
+}
+
+// SymbolOf returns the symbol err happened for, or blank if untagged.
+func SymbolOf(err error) string {
+	var symbolErr *SymbolError
+	if errors.As(err, &symbolErr) {
+		return symbolErr.Symbol
+	}
+	return ""
+}
+
+// ScopeOf returns the scope err happened in, or blank if untagged.
+func ScopeOf(err error) PairdumpScope {
+	var scopeErr *ScopeError
+	if errors.As(err, &scopeErr) {
+		return scopeErr.Scope
+	}
+	return ""
+}
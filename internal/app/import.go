@@ -0,0 +1,191 @@
+package app
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+)
+
+const ChecksumSuffix string = ".CHECKSUM"
+
+const (
+	ArchiveMonthlyLayout string = "2006-01"
+	ArchiveDailyLayout   string = "2006-01-02"
+)
+
+// ArchiveOneMonth is how Binance public data names the 1M interval in archive
+// names, 1M would clash with 1m on case-insensitive file systems.
+const ArchiveOneMonth string = "1mo"
+
+// ParseArchiveName extracts symbol, interval and the date of the first kline
+// from a Binance public data kline archive name such as
+// BTCUSDT-1h-2024-01.zip (monthly) or BTCUSDT-1h-2024-01-15.zip (daily).
+// The 1mo interval of archive names is returned as services.OneMonth.
+func ParseArchiveName(name string) (string, services.BinanceKlineInterval, time.Time, error) {
+	parts := strings.Split(strings.TrimSuffix(filepath.Base(name), ".zip"), "-")
+	if len(parts) != 4 && len(parts) != 5 {
+		return "", "", time.Time{}, fmt.Errorf("import: unexpected archive name %q", name)
+	}
+	interval := services.BinanceKlineInterval(parts[1])
+	if parts[1] == ArchiveOneMonth {
+		interval = services.OneMonth
+	}
+	if !interval.IsValid() {
+		return "", "", time.Time{}, fmt.Errorf("import: invalid interval %q in archive name %q", parts[1], name)
+	}
+	layout := ArchiveMonthlyLayout
+	if len(parts) == 5 {
+		layout = ArchiveDailyLayout
+	}
+	start, err := time.Parse(layout, strings.Join(parts[2:], "-"))
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("import: invalid date in archive name %q", name)
+	}
+	return parts[0], interval, start, nil
+}
+
+type klineArchive struct {
+	path     string
+	symbol   string
+	interval services.BinanceKlineInterval
+	start    time.Time
+}
+
+// VerifyChecksum compares the sha256 of path with the accompanying
+// path.CHECKSUM file, formatted as "<sha256>  <file name>".
+func VerifyChecksum(path string) error {
+	content, err := ioutil.ReadFile(path + ChecksumSuffix)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return fmt.Errorf("import: empty checksum file %s%s", path, ChecksumSuffix)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(sum, fields[0]) {
+		return fmt.Errorf("import: checksum mismatch for %s: expected %s, got %s", path, fields[0], sum)
+	}
+	return nil
+}
+
+// ReadArchiveKlines parses every CSV file in a kline archive, header rows are
+// skipped. The klines belong to binance.market, spot and futures archives
+// share their layout.
+func ReadArchiveKlines(path string, symbol string, interval services.BinanceKlineInterval) ([]services.BinanceKline, error) {
+	var config = services.GetConfig()
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var klines []services.BinanceKline
+	for _, zf := range zr.File {
+		if !strings.HasSuffix(zf.Name, ".csv") {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		records, err := csv.NewReader(rc).ReadAll()
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("import: %s/%s: %v", path, zf.Name, err)
+		}
+		for i, record := range records {
+			if i == 0 && len(record) > 0 && record[0] == "open_time" {
+				continue
+			}
+			kline, err := services.ParseBinanceKlineRecord(record)
+			if err != nil {
+				return nil, fmt.Errorf("import: %s/%s: row %d: %v", path, zf.Name, i+1, err)
+			}
+			kline.Market = string(config.Binance.Market)
+			kline.Symbol = symbol
+			kline.Interval = string(interval)
+			klines = append(klines, kline)
+		}
+	}
+	return klines, nil
+}
+
+// ImportKlines reads every kline archive in dir, verifies its checksum when
+// verify is set and passes its klines to write. Archives are imported by
+// symbol, interval and start date, so a monthly archive comes before the
+// daily ones of the same month and klines reach append-only sinks in openTime
+// order. It returns the number of archives found and imported.
+func ImportKlines(ctx context.Context, dir string, verify bool, write func(klines []services.BinanceKline) error) (int, int64, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.zip"))
+	if err != nil {
+		return 0, 0, NewScopeError(AppImport, ExitConfig, err)
+	}
+	imported := int64(0)
+	archives := make([]klineArchive, 0, len(paths))
+	for _, path := range paths {
+		symbol, interval, start, err := ParseArchiveName(path)
+		if err != nil {
+			return len(paths), imported, NewScopeError(AppImport, ExitError, err)
+		}
+		archives = append(archives, klineArchive{path: path, symbol: symbol, interval: interval, start: start})
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		a, b := archives[i], archives[j]
+		if a.symbol != b.symbol {
+			return a.symbol < b.symbol
+		}
+		if a.interval != b.interval {
+			return a.interval < b.interval
+		}
+		if !a.start.Equal(b.start) {
+			return a.start.Before(b.start)
+		}
+		// monthly archives before the daily one of their first day
+		return len(a.path) < len(b.path)
+	})
+	for _, archive := range archives {
+		if ctx.Err() != nil {
+			return len(paths), imported, ctx.Err()
+		}
+		path, symbol, interval := archive.path, archive.symbol, archive.interval
+		if verify {
+			if err := VerifyChecksum(path); err != nil {
+				return len(paths), imported, NewScopeError(AppImport, ExitError, err)
+			}
+		}
+		klines, err := ReadArchiveKlines(path, symbol, interval)
+		if err != nil {
+			return len(paths), imported, NewScopeError(AppImport, ExitError, err)
+		}
+		log.Printf("import: %s, symbol=%s, interval=%s, klines=%d\n", filepath.Base(path), symbol, interval, len(klines))
+		if len(klines) > 0 {
+			if err := write(klines); err != nil {
+				return len(paths), imported, err
+			}
+		}
+		imported++
+	}
+	return len(paths), imported, nil
+}
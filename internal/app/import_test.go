@@ -0,0 +1,43 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+)
+
+func TestParseArchiveName(t *testing.T) {
+	tests := []struct {
+		name     string
+		symbol   string
+		interval services.BinanceKlineInterval
+		start    time.Time
+		wantErr  bool
+	}{
+		{name: "BTCUSDT-1h-2024-01.zip", symbol: "BTCUSDT", interval: services.OneHour, start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "data/BTCUSDT-1m-2024-01-15.zip", symbol: "BTCUSDT", interval: services.OneMinute, start: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{name: "ETHUSDT-1mo-2023-12.zip", symbol: "ETHUSDT", interval: services.OneMonth, start: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "ETHUSDT-1M-2023-12.zip", symbol: "ETHUSDT", interval: services.OneMonth, start: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "BTCUSDT-7m-2024-01.zip", wantErr: true},
+		{name: "BTCUSDT-1h-2024-13.zip", wantErr: true},
+		{name: "BTCUSDT-1h.zip", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			symbol, interval, start, err := ParseArchiveName(tt.name)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if symbol != tt.symbol || interval != tt.interval || !start.Equal(tt.start) {
+				t.Errorf("got %s %s %v, want %s %s %v", symbol, interval, start, tt.symbol, tt.interval, tt.start)
+			}
+		})
+	}
+}
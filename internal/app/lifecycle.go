@@ -0,0 +1,93 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+)
+
+type SymbolEventType string
+
+const (
+	SymbolListed        SymbolEventType = "listed"
+	SymbolRelisted      SymbolEventType = "relisted"
+	SymbolStatusChanged SymbolEventType = "statusChanged"
+	SymbolDelisted      SymbolEventType = "delisted"
+)
+
+// SymbolEvent is a change in the lifecycle of a symbol, stored in the symbol
+// history.
+type SymbolEvent struct {
+	Symbol string          `json:"symbol" bson:"symbol"`
+	Type   SymbolEventType `json:"type" bson:"type"`
+	From   string          `json:"from,omitempty" bson:"from,omitempty"`
+	To     string          `json:"to,omitempty" bson:"to,omitempty"`
+	At     time.Time       `json:"at" bson:"at"`
+}
+
+func (e SymbolEvent) String() string {
+	switch e.Type {
+	case SymbolStatusChanged:
+		return fmt.Sprintf("%s %s %s -> %s", e.Symbol, e.Type, e.From, e.To)
+	case SymbolListed, SymbolRelisted:
+		return fmt.Sprintf("%s %s as %s", e.Symbol, e.Type, e.To)
+	}
+	return fmt.Sprintf("%s %s", e.Symbol, e.Type)
+}
+
+// StoredSymbolState is the lifecycle state of a stored symbol, Status is
+// blank for symbols stored without metadata.
+type StoredSymbolState struct {
+	Status   string
+	Delisted bool
+}
+
+// SymbolTracker is implemented by sinks that track the symbol lifecycle.
+type SymbolTracker interface {
+	// TrackSymbols compares every exchangeInfo symbol in exchange with the
+	// stored ones and with every symbol exchangeInfo returned before, ahead
+	// of the selected ones being upserted. It refreshes the status of every
+	// stored symbol, selected or not, marks stored symbols no longer in
+	// exchange as delisted, records the exchange symbols as seen, appends
+	// the events to the symbol history and returns them.
+	TrackSymbols(ctx context.Context, exchange []services.BinanceSymbol) ([]SymbolEvent, error)
+}
+
+// DiffSymbols returns the lifecycle events between the stored symbols, the
+// symbols seen in exchangeInfo before and the exchangeInfo symbols in
+// exchange. Symbols never seen before are listed, selected or not, so that a
+// symbol entering the selection is not mistaken for a new listing. Nothing
+// is listed while no symbol was seen yet. Status changes are reported for
+// every stored symbol, stored symbols not in exchange are delisted.
+func DiffSymbols(stored map[string]StoredSymbolState, seen map[string]bool, exchange []services.BinanceSymbol, now time.Time) []SymbolEvent {
+	var events []SymbolEvent
+	for _, symbol := range exchange {
+		state, ok := stored[symbol.Symbol]
+		switch {
+		case ok && state.Delisted:
+			events = append(events, SymbolEvent{Symbol: symbol.Symbol, Type: SymbolRelisted, From: state.Status, To: symbol.Status, At: now})
+		case ok && state.Status != "" && state.Status != symbol.Status:
+			events = append(events, SymbolEvent{Symbol: symbol.Symbol, Type: SymbolStatusChanged, From: state.Status, To: symbol.Status, At: now})
+		case !ok && len(seen) > 0 && !seen[symbol.Symbol]:
+			events = append(events, SymbolEvent{Symbol: symbol.Symbol, Type: SymbolListed, To: symbol.Status, At: now})
+		}
+	}
+	listed := map[string]bool{}
+	for _, symbol := range exchange {
+		listed[symbol.Symbol] = true
+	}
+	var delisted []string
+	for symbol, state := range stored {
+		if !state.Delisted && !listed[symbol] {
+			delisted = append(delisted, symbol)
+		}
+	}
+	sort.Strings(delisted)
+	for _, symbol := range delisted {
+		events = append(events, SymbolEvent{Symbol: symbol, Type: SymbolDelisted, From: stored[symbol].Status, At: now})
+	}
+	return events
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+)
+
+func TestDiffSymbols(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	exchange := []services.BinanceSymbol{
+		{Symbol: "BTCUSDT", Status: "TRADING"},
+		{Symbol: "ETHUSDT", Status: "BREAK"},
+		{Symbol: "NEWUSDT", Status: "TRADING"},
+	}
+	tests := []struct {
+		name   string
+		stored map[string]StoredSymbolState
+		seen   []string
+		want   []SymbolEvent
+	}{
+		{
+			name:   "nothing seen yet",
+			stored: map[string]StoredSymbolState{"BTCUSDT": {Status: "TRADING"}},
+		},
+		{
+			name: "seen before but not stored",
+			seen: []string{"BTCUSDT", "ETHUSDT", "NEWUSDT"},
+		},
+		{
+			name:   "new on the exchange",
+			stored: map[string]StoredSymbolState{"BTCUSDT": {Status: "TRADING"}},
+			seen:   []string{"BTCUSDT", "ETHUSDT"},
+			want:   []SymbolEvent{{Symbol: "NEWUSDT", Type: SymbolListed, To: "TRADING", At: now}},
+		},
+		{
+			name: "status changed, relisted and delisted",
+			stored: map[string]StoredSymbolState{
+				"BTCUSDT": {Status: "BREAK", Delisted: true},
+				"ETHUSDT": {Status: "TRADING"},
+				"NEWUSDT": {Status: "TRADING"},
+				"OLDUSDT": {Status: "TRADING"},
+			},
+			seen: []string{"BTCUSDT", "ETHUSDT", "NEWUSDT", "OLDUSDT"},
+			want: []SymbolEvent{
+				{Symbol: "BTCUSDT", Type: SymbolRelisted, From: "BREAK", To: "TRADING", At: now},
+				{Symbol: "ETHUSDT", Type: SymbolStatusChanged, From: "TRADING", To: "BREAK", At: now},
+				{Symbol: "OLDUSDT", Type: SymbolDelisted, From: "TRADING", At: now},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffSymbols(tt.stored, stringSet(tt.seen), exchange, now)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
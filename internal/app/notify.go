@@ -10,23 +10,51 @@ import (
 
 type PairdumpStatus string
 type PairdumpScope string
+type PairdumpProgress struct {
+	Jobs     int   `json:"jobs"`
+	JobsDone int64 `json:"jobsDone"`
+	Klines   int64 `json:"klines"`
+	Matched  int64 `json:"matched"`
+	Upserted int64 `json:"upserted"`
+	Modified int64 `json:"modified"`
+}
 type PairdumpStatusMessage struct {
-	Status  PairdumpStatus `json:"status"`
-	Scope   PairdumpScope  `json:"scope,omitempty"`
-	Message string         `json:"message,omitempty"`
+	Status    PairdumpStatus    `json:"status"`
+	Scope     PairdumpScope     `json:"scope,omitempty"`
+	Message   string            `json:"message,omitempty"`
+	Progress  *PairdumpProgress `json:"progress,omitempty"`
+	Symbol    string            `json:"symbol,omitempty"`
+	Failed    []string          `json:"failed,omitempty"`
+	Documents []DocumentError   `json:"documents,omitempty"`
 }
 
+// NotifyMaxDocuments caps the failed documents listed in an error
+// notification.
+const NotifyMaxDocuments int = 100
+
 const (
-	StatusStart PairdumpStatus = "start"
-	StatusDone  PairdumpStatus = "done"
-	StatusError PairdumpStatus = "error"
+	StatusStart     PairdumpStatus = "start"
+	StatusDone      PairdumpStatus = "done"
+	StatusError     PairdumpStatus = "error"
+	StatusCancelled PairdumpStatus = "cancelled"
+	StatusPartial   PairdumpStatus = "partial"
+	StatusListed    PairdumpStatus = "listed"
+	StatusDelisted  PairdumpStatus = "delisted"
 )
 
 const (
-	AppGetSymbols  PairdumpScope = "app.GetSymbols"
-	AppGetKlines   PairdumpScope = "app.GetKlines"
-	AppEnsureIndex PairdumpScope = "app.EnsureIndex"
-	AppBulkWrite   PairdumpScope = "app.BulkWrite"
+	AppGetSymbols   PairdumpScope = "app.GetSymbols"
+	AppGetKlines    PairdumpScope = "app.GetKlines"
+	AppEnsureIndex  PairdumpScope = "app.EnsureIndex"
+	AppEnsureSchema PairdumpScope = "app.EnsureSchema"
+	AppBulkWrite    PairdumpScope = "app.BulkWrite"
+	AppLastOpenTime PairdumpScope = "app.LastOpenTime"
+	AppConfig       PairdumpScope = "app.Config"
+	AppConnect      PairdumpScope = "app.Connect"
+	AppImport       PairdumpScope = "app.Import"
+	AppMigrate      PairdumpScope = "app.Migrate"
+	AppTrackSymbols PairdumpScope = "app.TrackSymbols"
+	AppRankSymbols  PairdumpScope = "app.RankSymbols"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
@@ -54,9 +82,57 @@ func NotifyError(ctx context.Context, scope PairdumpScope, err error) {
 	var config = services.GetConfig()
 	var rd = services.GetRedis()
 	if config.Notification.Enable {
-		m, _ := json.Marshal(PairdumpStatusMessage{Status: StatusError, Scope: scope, Message: err.Error()})
+		documents := DocumentErrorsOf(err)
+		if len(documents) > NotifyMaxDocuments {
+			documents = documents[:NotifyMaxDocuments]
+		}
+		m, _ := json.Marshal(PairdumpStatusMessage{Status: StatusError, Scope: scope, Message: err.Error(), Symbol: SymbolOf(err), Documents: documents})
 		log.Printf("notification: NotifyError -> %s\n", m)
 		result, err := rd.Publish(ctx, config.Notification.Channel, m)
 		log.Printf("notification: NotifyError -> result=%d, error=%v", result, err)
 	}
 }
+
+func NotifyCancelled(ctx context.Context, progress PairdumpProgress) {
+	var config = services.GetConfig()
+	var rd = services.GetRedis()
+	if config.Notification.Enable {
+		m, _ := json.Marshal(PairdumpStatusMessage{Status: StatusCancelled, Progress: &progress})
+		log.Printf("notification: NotifyCancelled -> %s\n", m)
+		result, err := rd.Publish(ctx, config.Notification.Channel, m)
+		log.Printf("notification: NotifyCancelled -> result=%d, error=%v", result, err)
+	}
+}
+
+func NotifyPartial(ctx context.Context, progress PairdumpProgress, failed []string) {
+	var config = services.GetConfig()
+	var rd = services.GetRedis()
+	if config.Notification.Enable {
+		m, _ := json.Marshal(PairdumpStatusMessage{Status: StatusPartial, Progress: &progress, Failed: failed})
+		log.Printf("notification: NotifyPartial -> %s\n", m)
+		result, err := rd.Publish(ctx, config.Notification.Channel, m)
+		log.Printf("notification: NotifyPartial -> result=%d, error=%v", result, err)
+	}
+}
+
+// NotifySymbolEvent publishes new listings, relistings included, and
+// delistings. Status changes are only recorded in the symbol history.
+func NotifySymbolEvent(ctx context.Context, event SymbolEvent) {
+	var config = services.GetConfig()
+	var rd = services.GetRedis()
+	var status PairdumpStatus
+	switch event.Type {
+	case SymbolListed, SymbolRelisted:
+		status = StatusListed
+	case SymbolDelisted:
+		status = StatusDelisted
+	default:
+		return
+	}
+	if config.Notification.Enable {
+		m, _ := json.Marshal(PairdumpStatusMessage{Status: status, Symbol: event.Symbol, Message: event.String()})
+		log.Printf("notification: NotifySymbolEvent -> %s\n", m)
+		result, err := rd.Publish(ctx, config.Notification.Channel, m)
+		log.Printf("notification: NotifySymbolEvent -> result=%d, error=%v", result, err)
+	}
+}
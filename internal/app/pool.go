@@ -0,0 +1,126 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+)
+
+type klinesJob struct {
+	symbol string
+	ki     services.KlinesInterval
+}
+
+// klinesPage is a page of klines fetched for a job, or the end of job when
+// done is set.
+type klinesPage struct {
+	klines []services.BinanceKline
+	done   *klinesJob
+}
+
+// DumpAllKlines fetches klines for every (symbol, interval) combination from
+// fetcher with a pool of binance.klines.workers fetchers. Pages are passed to
+// write one at a time on the calling goroutine, so writes are pipelined behind
+// the fetches. When ctx is cancelled no new jobs are started and pages already
+// fetched are still written. Once a job is fully written, sinks implementing
+// KlinesFlusher flush its symbol and interval. Job errors are tagged as
+// SymbolError, those that skip accepts are skipped, any other fetch or write
+// error stops the pool and is returned along with the number of
+// (symbol, interval) jobs fully dumped.
+func DumpAllKlines(ctx context.Context, config *services.Config, fetcher KlinesFetcher, sink Sink, symbols []string, intervals []services.KlinesInterval, write func(klines []services.BinanceKline) error, skip func(err error) bool) (int64, error) {
+	workers := config.Binance.Klines.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	poolCtx, poolCancel := context.WithCancel(ctx)
+	defer poolCancel()
+
+	var errMu sync.Mutex
+	var firstErr error
+	fail := func(err error) {
+		errMu.Lock()
+		defer errMu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			poolCancel()
+		}
+	}
+	failed := func() error {
+		errMu.Lock()
+		defer errMu.Unlock()
+		return firstErr
+	}
+
+	jobs := make(chan klinesJob)
+	pages := make(chan klinesPage, workers)
+
+	jobsDone := int64(0)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				err := DumpKlines(poolCtx, config, fetcher, sink, job.symbol, job.ki, func(klines []services.BinanceKline) error {
+					pages <- klinesPage{klines: klines}
+					return nil
+				})
+				if err != nil {
+					if poolCtx.Err() != nil {
+						continue
+					}
+					err = &SymbolError{Symbol: job.symbol, Interval: string(job.ki.Interval), Err: err}
+					if skip == nil || !skip(err) {
+						fail(err)
+					}
+					continue
+				}
+				if poolCtx.Err() == nil {
+					atomic.AddInt64(&jobsDone, 1)
+					done := job
+					pages <- klinesPage{done: &done}
+				}
+			}
+		}()
+	}
+
+	go func() {
+	feed:
+		for _, symbol := range symbols {
+			for _, ki := range intervals {
+				select {
+				case <-poolCtx.Done():
+					break feed
+				case jobs <- klinesJob{symbol: symbol, ki: ki}:
+				}
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(pages)
+	}()
+
+	flusher, _ := sink.(KlinesFlusher)
+	for page := range pages {
+		if failed() != nil {
+			continue
+		}
+		if page.done != nil {
+			if flusher != nil {
+				if err := flusher.FlushKlines(ctx, page.done.symbol, page.done.ki.Interval); err != nil {
+					fail(NewScopeError(AppBulkWrite, ExitStorage, err))
+				}
+			}
+			continue
+		}
+		if err := write(page.klines); err != nil {
+			fail(err)
+		}
+	}
+	if err := failed(); err != nil {
+		return jobsDone, err
+	}
+	return jobsDone, ctx.Err()
+}
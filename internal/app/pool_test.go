@@ -0,0 +1,207 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+)
+
+var testKlinesStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testKlines returns count closed hourly klines of symbol from
+// testKlinesStart.
+func testKlines(symbol string, count int) []services.BinanceKline {
+	var klines []services.BinanceKline
+	for i := 0; i < count; i++ {
+		openTime := testKlinesStart.Add(time.Duration(i) * time.Hour)
+		klines = append(klines, services.BinanceKline{
+			Market:    string(services.MarketSpot),
+			Symbol:    symbol,
+			Interval:  string(services.OneHour),
+			OpenTime:  openTime,
+			CloseTime: openTime.Add(time.Hour - time.Millisecond),
+			Open:      1,
+			Close:     1,
+		})
+	}
+	return klines
+}
+
+// fakeFetcher serves count klines per symbol, or the error of the symbol in
+// errs. It calls cancel on request cancelAt.
+type fakeFetcher struct {
+	mu       sync.Mutex
+	count    int
+	errs     map[string]error
+	calls    map[string]int
+	requests int
+	cancelAt int
+	cancel   func()
+}
+
+func (f *fakeFetcher) Klines(ctx context.Context, symbol string, interval services.BinanceKlineInterval, opts ...*services.BinanceKlinesOptions) ([]services.BinanceKline, error) {
+	f.mu.Lock()
+	f.calls[symbol]++
+	f.requests++
+	if f.requests == f.cancelAt {
+		f.cancel()
+	}
+	f.mu.Unlock()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err, ok := f.errs[symbol]; ok {
+		return nil, err
+	}
+	klines := testKlines(symbol, f.count)
+	opt := opts[0]
+	if opt.StartTime == nil {
+		if len(klines) > *opt.Limit {
+			klines = klines[len(klines)-*opt.Limit:]
+		}
+		return klines, nil
+	}
+	var page []services.BinanceKline
+	for _, kline := range klines {
+		if kline.OpenTime.UnixMilli() >= *opt.StartTime && len(page) < *opt.Limit {
+			page = append(page, kline)
+		}
+	}
+	return page, nil
+}
+
+func TestDumpAllKlines(t *testing.T) {
+	tests := []struct {
+		name        string
+		backfill    bool
+		incremental bool
+		limit       int
+		stored      int
+		symbols     []string
+		errs        map[string]error
+		cancelAt    int
+		wantJobs    int64
+		wantExit    ExitCode
+		wantSymbol  string
+		wantKlines  map[string]int
+		wantCalls   map[string]int
+	}{
+		{
+			name:       "latest page",
+			limit:      4,
+			symbols:    []string{"BTCUSDT", "ETHUSDT"},
+			wantJobs:   2,
+			wantKlines: map[string]int{"BTCUSDT": 4, "ETHUSDT": 4},
+			wantCalls:  map[string]int{"BTCUSDT": 1, "ETHUSDT": 1},
+		},
+		{
+			name:       "backfill paging",
+			backfill:   true,
+			limit:      4,
+			symbols:    []string{"BTCUSDT", "ETHUSDT"},
+			wantJobs:   2,
+			wantKlines: map[string]int{"BTCUSDT": 10, "ETHUSDT": 10},
+			wantCalls:  map[string]int{"BTCUSDT": 3, "ETHUSDT": 3},
+		},
+		{
+			name:       "backfill full last page",
+			backfill:   true,
+			limit:      5,
+			symbols:    []string{"BTCUSDT"},
+			wantJobs:   1,
+			wantKlines: map[string]int{"BTCUSDT": 10},
+			wantCalls:  map[string]int{"BTCUSDT": 3},
+		},
+		{
+			name:        "incremental resume",
+			incremental: true,
+			limit:       4,
+			stored:      6,
+			symbols:     []string{"BTCUSDT", "ETHUSDT"},
+			wantJobs:    2,
+			wantKlines:  map[string]int{"BTCUSDT": 10, "ETHUSDT": 10},
+			wantCalls:   map[string]int{"BTCUSDT": 2, "ETHUSDT": 3},
+		},
+		{
+			name:       "skip client error",
+			backfill:   true,
+			limit:      4,
+			symbols:    []string{"BTCUSDT", "BADUSDT", "ETHUSDT"},
+			errs:       map[string]error{"BADUSDT": &services.BinanceClientError{StatusCode: 400, Code: -1121, Msg: "Invalid symbol."}},
+			wantJobs:   2,
+			wantKlines: map[string]int{"BTCUSDT": 10, "ETHUSDT": 10},
+		},
+		{
+			name:       "fail on rate limit",
+			backfill:   true,
+			limit:      4,
+			symbols:    []string{"BADUSDT"},
+			errs:       map[string]error{"BADUSDT": &services.BinanceRateLimitedError{RetryAfter: time.Second}},
+			wantExit:   ExitUpstream,
+			wantSymbol: "BADUSDT",
+			wantKlines: map[string]int{},
+		},
+		{
+			name:       "cancellation",
+			backfill:   true,
+			limit:      4,
+			symbols:    []string{"BTCUSDT"},
+			cancelAt:   2,
+			wantExit:   ExitCancelled,
+			wantKlines: map[string]int{"BTCUSDT": 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &services.Config{}
+			config.Binance.Market = services.MarketSpot
+			config.Binance.Klines.Backfill = tt.backfill
+			config.Binance.Klines.Incremental = tt.incremental
+			config.Binance.Klines.Workers = 1
+			config.Storage.WriteMode = services.WriteModeInsert
+			sink := NewMemorySink(config)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.stored > 0 {
+				if _, err := sink.UpsertKlines(ctx, testKlines("BTCUSDT", tt.stored)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fetcher := &fakeFetcher{count: 10, errs: tt.errs, calls: map[string]int{}, cancelAt: tt.cancelAt, cancel: cancel}
+			intervals := []services.KlinesInterval{{Interval: services.OneHour, Limit: tt.limit}}
+
+			write := func(klines []services.BinanceKline) error {
+				_, err := sink.UpsertKlines(ctx, klines)
+				return err
+			}
+			jobs, err := DumpAllKlines(ctx, config, fetcher, sink, tt.symbols, intervals, write, IsSymbolOnly)
+
+			if got := ExitCodeOf(err); got != tt.wantExit {
+				t.Fatalf("exit code = %d, want %d (err %v)", got, tt.wantExit, err)
+			}
+			if got := SymbolOf(err); got != tt.wantSymbol {
+				t.Errorf("error symbol = %q, want %q", got, tt.wantSymbol)
+			}
+			if tt.wantExit == ExitOK && jobs != tt.wantJobs {
+				t.Errorf("jobs done = %d, want %d", jobs, tt.wantJobs)
+			}
+			stored := map[string]int{}
+			for key := range sink.Klines {
+				stored[key.symbol]++
+			}
+			for _, symbol := range tt.symbols {
+				if stored[symbol] != tt.wantKlines[symbol] {
+					t.Errorf("%s klines stored = %d, want %d", symbol, stored[symbol], tt.wantKlines[symbol])
+				}
+			}
+			for symbol, want := range tt.wantCalls {
+				if got := fetcher.calls[symbol]; got != want {
+					t.Errorf("%s klines requests = %d, want %d", symbol, got, want)
+				}
+			}
+		})
+	}
+}
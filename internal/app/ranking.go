@@ -0,0 +1,120 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+)
+
+// StablecoinQuote is the quote asset stablecoin quotes are ranked under.
+const StablecoinQuote string = "USD"
+
+// RankedSymbol is a symbol ranked by its 24h quote volume among the symbols
+// sharing its normalized quote asset.
+type RankedSymbol struct {
+	Symbol      string  `json:"symbol"`
+	Quote       string  `json:"quote"`
+	Rank        int     `json:"rank"`
+	QuoteVolume float64 `json:"quoteVolume"`
+	Trades      int64   `json:"trades"`
+	Kept        bool    `json:"kept"`
+}
+
+func (r RankedSymbol) String() string {
+	kept := "dropped"
+	if r.Kept {
+		kept = "kept"
+	}
+	return fmt.Sprintf("%s #%d %s quoteVolume=%.2f trades=%d %s", r.Quote, r.Rank, r.Symbol, r.QuoteVolume, r.Trades, kept)
+}
+
+// RankingEnabled reports whether binance.symbols.ranking selects symbols.
+func RankingEnabled(config *services.Config) bool {
+	ranking := config.Binance.Symbols.Ranking
+	return ranking.Top > 0 || ranking.MinQuoteVolume > 0 || ranking.MinTrades > 0
+}
+
+// normalizeQuote returns the quote asset symbols are ranked under, the
+// stablecoins are all ranked as StablecoinQuote.
+func normalizeQuote(asset string, stablecoins map[string]bool) string {
+	if stablecoins[asset] {
+		return StablecoinQuote
+	}
+	return asset
+}
+
+// RankSymbols keeps the symbols above binance.symbols.ranking minQuoteVolume
+// and minTrades, and of those only the top ones by 24h quote volume per
+// normalized quote asset. Symbols with no 24h ticker are dropped, allowed
+// symbols are always kept. The ranking of every symbol is returned along
+// with those kept.
+func RankSymbols(ctx context.Context, symbols []services.BinanceSymbol) ([]services.BinanceSymbol, []RankedSymbol, error) {
+	var config = services.GetConfig()
+	var binance = services.GetBinance()
+	ranking := config.Binance.Symbols.Ranking
+	tickers, err := binance.Ticker24hr(ctx)
+	if err != nil {
+		return nil, nil, upstreamError(ctx, AppRankSymbols, err)
+	}
+	byName := map[string]services.BinanceTicker24hr{}
+	for _, ticker := range tickers {
+		byName[ticker.Symbol] = ticker
+	}
+
+	stablecoins := stringSet(ranking.Stablecoins)
+	var ranked []RankedSymbol
+	for _, symbol := range symbols {
+		r := RankedSymbol{Symbol: symbol.Symbol, Quote: normalizeQuote(symbol.QuoteAsset, stablecoins)}
+		if ticker, ok := byName[symbol.Symbol]; ok {
+			var contractSize int64
+			if symbol.Contract != nil {
+				contractSize = symbol.Contract.ContractSize
+			}
+			quoteVolume, err := ticker.QuoteVolumeValue(contractSize)
+			if err != nil {
+				return nil, nil, NewScopeError(AppRankSymbols, ExitUpstream, err)
+			}
+			r.QuoteVolume = quoteVolume
+			r.Trades = ticker.Count
+		}
+		ranked = append(ranked, r)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Quote != ranked[j].Quote {
+			return ranked[i].Quote < ranked[j].Quote
+		}
+		if ranked[i].QuoteVolume != ranked[j].QuoteVolume {
+			return ranked[i].QuoteVolume > ranked[j].QuoteVolume
+		}
+		return ranked[i].Symbol < ranked[j].Symbol
+	})
+
+	allow := stringSet(config.Binance.Symbols.Allow)
+	kept := map[string]bool{}
+	ranks := map[string]int{}
+	taken := map[string]int{}
+	for i := range ranked {
+		r := &ranked[i]
+		ranks[r.Quote]++
+		r.Rank = ranks[r.Quote]
+		_, hasTicker := byName[r.Symbol]
+		if hasTicker && r.QuoteVolume >= ranking.MinQuoteVolume && r.Trades >= ranking.MinTrades {
+			taken[r.Quote]++
+			r.Kept = ranking.Top <= 0 || taken[r.Quote] <= ranking.Top
+		}
+		if allow[r.Symbol] {
+			r.Kept = true
+		}
+		kept[r.Symbol] = r.Kept
+	}
+
+	var selected []services.BinanceSymbol
+	for _, symbol := range symbols {
+		if kept[symbol.Symbol] {
+			selected = append(selected, symbol)
+		}
+	}
+	return selected, ranked, nil
+}
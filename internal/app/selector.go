@@ -0,0 +1,98 @@
+package app
+
+import (
+	"regexp"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+)
+
+// SymbolSelector decides which exchangeInfo symbols are dumped, built from
+// binance.filterPattern and binance.symbols.
+type SymbolSelector struct {
+	filterPattern *regexp.Regexp
+	include       []*regexp.Regexp
+	exclude       []*regexp.Regexp
+	quoteAssets   map[string]bool
+	baseAssets    map[string]bool
+	status        map[string]bool
+	permissions   []string
+	contractTypes map[string]bool
+	allow         map[string]bool
+	deny          map[string]bool
+}
+
+func stringSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := map[string]bool{}
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+func compilePatterns(patterns []string) []*regexp.Regexp {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		compiled = append(compiled, regexp.MustCompile(pattern))
+	}
+	return compiled
+}
+
+// NewSymbolSelector builds the selector of config, its patterns are checked
+// when the config is loaded.
+func NewSymbolSelector(config *services.Config) *SymbolSelector {
+	symbols := config.Binance.Symbols
+	return &SymbolSelector{
+		filterPattern: regexp.MustCompile(config.Binance.FilterPattern),
+		include:       compilePatterns(symbols.Include),
+		exclude:       compilePatterns(symbols.Exclude),
+		quoteAssets:   stringSet(symbols.QuoteAssets),
+		baseAssets:    stringSet(symbols.BaseAssets),
+		status:        stringSet(symbols.Status),
+		permissions:   symbols.Permissions,
+		contractTypes: stringSet(symbols.ContractTypes),
+		allow:         stringSet(symbols.Allow),
+		deny:          stringSet(symbols.Deny),
+	}
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Select reports whether symbol is dumped.
+func (sel *SymbolSelector) Select(symbol services.BinanceSymbol) bool {
+	switch {
+	case sel.deny[symbol.Symbol]:
+		return false
+	case sel.allow[symbol.Symbol]:
+		return true
+	case !sel.filterPattern.MatchString(symbol.Symbol):
+		return false
+	case len(sel.include) > 0 && !matchAny(sel.include, symbol.Symbol):
+		return false
+	case matchAny(sel.exclude, symbol.Symbol):
+		return false
+	case sel.quoteAssets != nil && !sel.quoteAssets[symbol.QuoteAsset]:
+		return false
+	case sel.baseAssets != nil && !sel.baseAssets[symbol.BaseAsset]:
+		return false
+	case sel.status != nil && !sel.status[symbol.Status]:
+		return false
+	case sel.contractTypes != nil && (symbol.Contract == nil || !sel.contractTypes[symbol.Contract.ContractType]):
+		return false
+	}
+	for _, permission := range sel.permissions {
+		if !symbol.HasPermission(permission) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+)
+
+const (
+	SinkMongo    string = "mongo"
+	SinkMemory   string = "memory"
+	SinkSQLite   string = "sqlite"
+	SinkPostgres string = "postgres"
+	SinkParquet  string = "parquet"
+	SinkCSV      string = "csv"
+	SinkJSONL    string = "jsonl"
+)
+
+// UpsertResult counts the documents a sink matched (already stored),
+// modified (already stored and rewritten) and upserted (newly inserted).
+type UpsertResult struct {
+	MatchedCount  int64
+	ModifiedCount int64
+	UpsertedCount int64
+}
+
+// Sink is a storage destination for symbols and klines. Upserts may return a
+// result along with an error, counting the documents written despite it.
+type Sink interface {
+	// Connect opens the connection to the destination.
+	Connect(ctx context.Context) error
+	// Close releases the connection.
+	Close(ctx context.Context) error
+	// EnsureSchema creates the collections, tables and unique keys if absent.
+	EnsureSchema(ctx context.Context) error
+	// UpsertSymbols inserts the symbols not stored yet and refreshes the
+	// metadata of stored ones, bumping updatedAt only when it changed.
+	UpsertSymbols(ctx context.Context, symbols []services.BinanceSymbol) (*UpsertResult, error)
+	// UpsertKlines inserts the klines not stored yet, keyed by market,
+	// symbol, interval and openTime, and rewrites stored ones as
+	// storage.writeMode says.
+	UpsertKlines(ctx context.Context, klines []services.BinanceKline) (*UpsertResult, error)
+	// LastOpenTime returns the latest stored openTime for symbol and
+	// interval of binance.market, or nil when nothing is stored yet.
+	LastOpenTime(ctx context.Context, symbol string, interval services.BinanceKlineInterval) (*time.Time, error)
+}
+
+// Flusher is implemented by sinks that buffer writes, Flush persists
+// everything buffered so far.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// KlinesFlusher is implemented by sinks that buffer klines per symbol and
+// interval, FlushKlines persists those buffered for symbol and interval once
+// their job is done.
+type KlinesFlusher interface {
+	FlushKlines(ctx context.Context, symbol string, interval services.BinanceKlineInterval) error
+}
+
+// NewSink returns the sink selected by storage.type, defaulting to mongo.
+func NewSink(config *services.Config) (Sink, error) {
+	switch config.Storage.Type {
+	case SinkParquet, SinkCSV, SinkJSONL:
+		if config.Storage.WriteMode != services.WriteModeInsert {
+			return nil, fmt.Errorf("sink: storage type %s is append-only, writeMode %q is not supported", config.Storage.Type, config.Storage.WriteMode)
+		}
+	}
+	if config.Storage.Type == SinkParquet && config.Storage.Decimal {
+		return nil, fmt.Errorf("sink: storage type %s stores float64 columns, decimal is not supported", config.Storage.Type)
+	}
+	switch config.Storage.Type {
+	case "", SinkMongo:
+		if config.Mongo.Binance.Timeseries && config.Storage.WriteMode != services.WriteModeInsert {
+			return nil, fmt.Errorf("sink: mongo time-series klines are never rewritten, writeMode %q is not supported", config.Storage.WriteMode)
+		}
+		return NewMongoSink(), nil
+	case SinkMemory:
+		return NewMemorySink(config), nil
+	case SinkSQLite:
+		return NewSQLiteSink(), nil
+	case SinkPostgres:
+		return NewPostgresSink(config), nil
+	case SinkParquet:
+		return NewParquetSink(), nil
+	case SinkCSV:
+		return NewFileSink(FileFormatCSV), nil
+	case SinkJSONL:
+		return NewFileSink(FileFormatJSONL), nil
+	}
+	return nil, fmt.Errorf("sink: unknown storage type %q", config.Storage.Type)
+}
+
+// klineDecimalValues returns open, high, low, close, volume, quote asset
+// volume, taker buy base and taker buy quote asset volume of k, as exact
+// decimal strings when decimal is set and as float64 otherwise.
+func klineDecimalValues(k services.BinanceKline, decimal bool) [8]interface{} {
+	if decimal {
+		d := k.DecimalStrings()
+		return [8]interface{}{
+			d.Open, d.High, d.Low, d.Close, d.Volume,
+			d.QuoteAssetVolume, d.TakerBuyBaseAssetVolume, d.TakerBuyQuoteAssetVolume,
+		}
+	}
+	return [8]interface{}{
+		k.Open, k.High, k.Low, k.Close, k.Volume,
+		k.QuoteAssetVolume, k.TakerBuyBaseAssetVolume, k.TakerBuyQuoteAssetVolume,
+	}
+}
@@ -0,0 +1,428 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	FileFormatCSV   string = "csv"
+	FileFormatJSONL string = "jsonl"
+
+	FileSymbolsName string = "symbols"
+)
+
+// fileKline is the JSON Lines representation of a kline, times are unix
+// milliseconds like the Binance API returns them. Prices and volumes are JSON
+// numbers, written verbatim from the Binance decimal strings with
+// storage.decimal.
+type fileKline struct {
+	Market                   string          `json:"market"`
+	Symbol                   string          `json:"symbol"`
+	Interval                 string          `json:"interval"`
+	OpenTime                 int64           `json:"openTime"`
+	Open                     jsoniter.Number `json:"open"`
+	High                     jsoniter.Number `json:"high"`
+	Low                      jsoniter.Number `json:"low"`
+	Close                    jsoniter.Number `json:"close"`
+	Volume                   jsoniter.Number `json:"volume"`
+	CloseTime                int64           `json:"closeTime"`
+	QuoteAssetVolume         jsoniter.Number `json:"quoteAssetVolume"`
+	NumberOfTrades           int64           `json:"numberOfTrades"`
+	TakerBuyBaseAssetVolume  jsoniter.Number `json:"takerBuyBaseAssetVolume"`
+	TakerBuyQuoteAssetVolume jsoniter.Number `json:"takerBuyQuoteAssetVolume"`
+}
+
+// fileSymbol is a line of the symbols file, CSV lines hold symbol,
+// createdAt, updatedAt and info as JSON. Files written before the metadata
+// was stored only hold the symbol.
+type fileSymbol struct {
+	Symbol    string                  `json:"symbol"`
+	CreatedAt int64                   `json:"createdAt,omitempty"`
+	UpdatedAt int64                   `json:"updatedAt,omitempty"`
+	Info      *services.BinanceSymbol `json:"info,omitempty"`
+}
+
+// FileSink writes one CSV or JSON Lines file per symbol and interval, e.g.
+// BTCUSDT-1h.csv, optionally gzipped. Futures markets are written under a
+// subdir of file.dir named after the market. CSV rows use the 12-column
+// layout of the data.binance.vision kline files. Appends are idempotent,
+// klines not newer than the last openTime in the file are skipped.
+type FileSink struct {
+	mu      sync.Mutex
+	format  string
+	dir     string
+	gzip    bool
+	decimal bool
+	symbols map[string]*fileSymbol
+	order   []string
+	last    map[string]int64
+}
+
+func NewFileSink(format string) *FileSink {
+	return &FileSink{format: format}
+}
+
+func (s *FileSink) Connect(ctx context.Context) error {
+	var config = services.GetConfig()
+	s.dir = config.File.Dir
+	s.gzip = config.File.Gzip
+	s.decimal = config.Storage.Decimal
+	s.symbols = map[string]*fileSymbol{}
+	s.order = nil
+	s.last = map[string]int64{}
+	return s.readLines(s.path(FileSymbolsName), func(line []byte) error {
+		symbol, err := s.parseSymbol(line)
+		if err != nil {
+			return err
+		}
+		if _, ok := s.symbols[symbol.Symbol]; !ok {
+			s.order = append(s.order, symbol.Symbol)
+		}
+		s.symbols[symbol.Symbol] = symbol
+		return nil
+	})
+}
+
+func (s *FileSink) Close(ctx context.Context) error {
+	return nil
+}
+
+func (s *FileSink) EnsureSchema(ctx context.Context) error {
+	return os.MkdirAll(s.dir, 0755)
+}
+
+func (s *FileSink) path(name string) string {
+	p := filepath.Join(s.dir, name+"."+s.format)
+	if s.gzip {
+		p += ".gz"
+	}
+	return p
+}
+
+func (s *FileSink) klinesPath(symbol string, interval string) string {
+	return s.path(symbol + "-" + interval)
+}
+
+// readLines calls fn with every line of path, a missing file has no lines.
+func (s *FileSink) readLines(path string, fn func(line []byte) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if s.gzip {
+		gz, err := gzip.NewReader(f)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := fn(scanner.Bytes()); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// appendLines appends lines to path, gzipped files get a new gzip member.
+func (s *FileSink) appendLines(path string, lines [][]byte) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if s.gzip {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	bw := bufio.NewWriter(w)
+	for _, line := range lines {
+		bw.Write(line)
+		bw.WriteByte('\n')
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func (s *FileSink) parseSymbol(line []byte) (*fileSymbol, error) {
+	var symbol fileSymbol
+	if s.format == FileFormatJSONL {
+		err := json.Unmarshal(line, &symbol)
+		return &symbol, err
+	}
+	record, err := csv.NewReader(bytes.NewReader(line)).Read()
+	if err != nil {
+		return nil, err
+	}
+	symbol.Symbol = record[0]
+	if len(record) < 4 {
+		return &symbol, nil
+	}
+	if symbol.CreatedAt, err = strconv.ParseInt(record[1], 10, 64); err != nil {
+		return nil, err
+	}
+	if symbol.UpdatedAt, err = strconv.ParseInt(record[2], 10, 64); err != nil {
+		return nil, err
+	}
+	symbol.Info = &services.BinanceSymbol{}
+	if err := json.Unmarshal([]byte(record[3]), symbol.Info); err != nil {
+		return nil, err
+	}
+	return &symbol, nil
+}
+
+func (s *FileSink) formatSymbol(symbol *fileSymbol) ([]byte, error) {
+	if s.format == FileFormatJSONL {
+		return json.Marshal(symbol)
+	}
+	if symbol.Info == nil {
+		return []byte(symbol.Symbol), nil
+	}
+	info, err := json.Marshal(symbol.Info)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{
+		symbol.Symbol,
+		strconv.FormatInt(symbol.CreatedAt, 10),
+		strconv.FormatInt(symbol.UpdatedAt, 10),
+		string(info),
+	})
+	w.Flush()
+	return bytes.TrimRight(buf.Bytes(), "\n"), w.Error()
+}
+
+func (s *FileSink) parseOpenTime(line []byte) (int64, error) {
+	if s.format == FileFormatJSONL {
+		var kline fileKline
+		err := json.Unmarshal(line, &kline)
+		return kline.OpenTime, err
+	}
+	for i, c := range line {
+		if c == ',' {
+			return strconv.ParseInt(string(line[:i]), 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("invalid csv row %q", line)
+}
+
+func (s *FileSink) formatKline(k services.BinanceKline) ([]byte, error) {
+	var v [8]string
+	for i, value := range klineDecimalValues(k, s.decimal) {
+		switch value := value.(type) {
+		case string:
+			v[i] = value
+		case float64:
+			v[i] = strconv.FormatFloat(value, 'f', -1, 64)
+		}
+	}
+	if s.format == FileFormatJSONL {
+		return json.Marshal(fileKline{
+			Market:                   k.Market,
+			Symbol:                   k.Symbol,
+			Interval:                 k.Interval,
+			OpenTime:                 k.OpenTime.UnixMilli(),
+			Open:                     jsoniter.Number(v[0]),
+			High:                     jsoniter.Number(v[1]),
+			Low:                      jsoniter.Number(v[2]),
+			Close:                    jsoniter.Number(v[3]),
+			Volume:                   jsoniter.Number(v[4]),
+			CloseTime:                k.CloseTime.UnixMilli(),
+			QuoteAssetVolume:         jsoniter.Number(v[5]),
+			NumberOfTrades:           k.NumberOfTrades,
+			TakerBuyBaseAssetVolume:  jsoniter.Number(v[6]),
+			TakerBuyQuoteAssetVolume: jsoniter.Number(v[7]),
+		})
+	}
+	return []byte(strings.Join([]string{
+		strconv.FormatInt(k.OpenTime.UnixMilli(), 10),
+		v[0],
+		v[1],
+		v[2],
+		v[3],
+		v[4],
+		strconv.FormatInt(k.CloseTime.UnixMilli(), 10),
+		v[5],
+		strconv.FormatInt(k.NumberOfTrades, 10),
+		v[6],
+		v[7],
+		"0",
+	}, ",")), nil
+}
+
+// UpsertSymbols appends new symbols to the symbols file. When the metadata
+// of a stored symbol changed, the whole file is rewritten instead.
+func (s *FileSink) UpsertSymbols(ctx context.Context, symbols []services.BinanceSymbol) (*UpsertResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := &UpsertResult{}
+	now := time.Now().UnixMilli()
+	var added []*fileSymbol
+	for i := range symbols {
+		info := symbols[i]
+		if stored, ok := s.symbols[info.Symbol]; ok {
+			result.MatchedCount++
+			if stored.Info != nil && stored.Info.Equal(info) {
+				continue
+			}
+			if stored.CreatedAt == 0 {
+				stored.CreatedAt = now
+			}
+			stored.UpdatedAt = now
+			stored.Info = &info
+			result.ModifiedCount++
+			continue
+		}
+		symbol := &fileSymbol{Symbol: info.Symbol, CreatedAt: now, UpdatedAt: now, Info: &info}
+		s.symbols[info.Symbol] = symbol
+		s.order = append(s.order, info.Symbol)
+		added = append(added, symbol)
+		result.UpsertedCount++
+	}
+	if result.ModifiedCount > 0 {
+		return result, s.rewriteSymbols()
+	}
+	var lines [][]byte
+	for _, symbol := range added {
+		line, err := s.formatSymbol(symbol)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) > 0 {
+		if err := s.appendLines(s.path(FileSymbolsName), lines); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// rewriteSymbols replaces the symbols file with every known symbol. Must
+// hold s.mu.
+func (s *FileSink) rewriteSymbols() error {
+	var lines [][]byte
+	for _, name := range s.order {
+		line, err := s.formatSymbol(s.symbols[name])
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+	}
+	path := s.path(FileSymbolsName)
+	if err := os.Remove(path + ".tmp"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := s.appendLines(path+".tmp", lines); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// lastOpenTime returns the last openTime written for symbol and interval in
+// unix milliseconds, reading the file on first use. Must hold s.mu.
+func (s *FileSink) lastOpenTime(symbol string, interval string) (int64, bool, error) {
+	key := symbol + "|" + interval
+	if last, ok := s.last[key]; ok {
+		return last, last >= 0, nil
+	}
+	last := int64(-1)
+	err := s.readLines(s.klinesPath(symbol, interval), func(line []byte) error {
+		openTime, err := s.parseOpenTime(line)
+		if err != nil {
+			return err
+		}
+		if openTime > last {
+			last = openTime
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	s.last[key] = last
+	return last, last >= 0, nil
+}
+
+func (s *FileSink) UpsertKlines(ctx context.Context, klines []services.BinanceKline) (*UpsertResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := &UpsertResult{}
+	lines := map[string][][]byte{}
+	for _, kline := range klines {
+		last, ok, err := s.lastOpenTime(kline.Symbol, kline.Interval)
+		if err != nil {
+			return nil, err
+		}
+		openTime := kline.OpenTime.UnixMilli()
+		if ok && openTime <= last {
+			result.MatchedCount++
+			continue
+		}
+		line, err := s.formatKline(kline)
+		if err != nil {
+			return nil, err
+		}
+		path := s.klinesPath(kline.Symbol, kline.Interval)
+		lines[path] = append(lines[path], line)
+		s.last[kline.Symbol+"|"+kline.Interval] = openTime
+		result.UpsertedCount++
+	}
+	for path, l := range lines {
+		if err := s.appendLines(path, l); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (s *FileSink) LastOpenTime(ctx context.Context, symbol string, interval services.BinanceKlineInterval) (*time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last, ok, err := s.lastOpenTime(symbol, string(interval))
+	if err != nil || !ok {
+		return nil, err
+	}
+	t := time.UnixMilli(last)
+	return &t, nil
+}
@@ -0,0 +1,172 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+)
+
+type memoryKlineKey struct {
+	market   string
+	symbol   string
+	interval string
+	openTime int64
+}
+
+// MemorySymbol is a symbol kept by MemorySink.
+type MemorySymbol struct {
+	Info            services.BinanceSymbol
+	Delisted        bool
+	DelistedAt      time.Time
+	StatusChangedAt time.Time
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// MemorySink keeps symbols and klines in memory, for dry runs and tests.
+type MemorySink struct {
+	mu      sync.Mutex
+	config  *services.Config
+	Symbols map[string]MemorySymbol
+	Klines  map[memoryKlineKey]services.BinanceKline
+	History []SymbolEvent
+	// Seen holds every symbol exchangeInfo returned and when it was first
+	// seen
+	Seen map[string]time.Time
+}
+
+func NewMemorySink(config *services.Config) *MemorySink {
+	return &MemorySink{
+		config:  config,
+		Symbols: map[string]MemorySymbol{},
+		Klines:  map[memoryKlineKey]services.BinanceKline{},
+		Seen:    map[string]time.Time{},
+	}
+}
+
+func (s *MemorySink) Connect(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemorySink) Close(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemorySink) EnsureSchema(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemorySink) UpsertSymbols(ctx context.Context, symbols []services.BinanceSymbol) (*UpsertResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := &UpsertResult{}
+	now := time.Now()
+	for _, symbol := range symbols {
+		if stored, ok := s.Symbols[symbol.Symbol]; ok {
+			result.MatchedCount++
+			if !stored.Info.Equal(symbol) {
+				stored.Info = symbol
+				stored.UpdatedAt = now
+				s.Symbols[symbol.Symbol] = stored
+				result.ModifiedCount++
+			}
+			continue
+		}
+		s.Symbols[symbol.Symbol] = MemorySymbol{Info: symbol, CreatedAt: now, UpdatedAt: now}
+		result.UpsertedCount++
+	}
+	return result, nil
+}
+
+// TrackSymbols applies the lifecycle events to the stored symbols, their
+// status is refreshed here as symbols no longer selected are not upserted.
+func (s *MemorySink) TrackSymbols(ctx context.Context, exchange []services.BinanceSymbol) ([]SymbolEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := map[string]StoredSymbolState{}
+	for name, symbol := range s.Symbols {
+		stored[name] = StoredSymbolState{Status: symbol.Info.Status, Delisted: symbol.Delisted}
+	}
+	seen := map[string]bool{}
+	for name := range s.Seen {
+		seen[name] = true
+	}
+	now := time.Now()
+	events := DiffSymbols(stored, seen, exchange, now)
+	for _, symbol := range exchange {
+		if !seen[symbol.Symbol] {
+			s.Seen[symbol.Symbol] = now
+		}
+	}
+	for _, event := range events {
+		symbol, ok := s.Symbols[event.Symbol]
+		if !ok {
+			continue
+		}
+		switch event.Type {
+		case SymbolStatusChanged:
+			symbol.Info.Status = event.To
+			symbol.StatusChangedAt = event.At
+			symbol.UpdatedAt = event.At
+		case SymbolRelisted:
+			symbol.Info.Status = event.To
+			symbol.Delisted = false
+			symbol.DelistedAt = time.Time{}
+			symbol.StatusChangedAt = event.At
+			symbol.UpdatedAt = event.At
+		case SymbolDelisted:
+			symbol.Delisted = true
+			symbol.DelistedAt = event.At
+			symbol.UpdatedAt = event.At
+		}
+		s.Symbols[event.Symbol] = symbol
+	}
+	s.History = append(s.History, events...)
+	return events, nil
+}
+
+func (s *MemorySink) UpsertKlines(ctx context.Context, klines []services.BinanceKline) (*UpsertResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := &UpsertResult{}
+	for _, kline := range klines {
+		key := memoryKlineKey{market: kline.Market, symbol: kline.Symbol, interval: kline.Interval, openTime: kline.OpenTime.UnixMilli()}
+		if stored, ok := s.Klines[key]; ok {
+			result.MatchedCount++
+			switch s.config.Storage.WriteMode {
+			case services.WriteModeReplace:
+				kline.CreatedAt = stored.CreatedAt
+				s.Klines[key] = kline
+				result.ModifiedCount++
+			case services.WriteModeUpdate:
+				if !stored.SameValues(kline) {
+					kline.CreatedAt = stored.CreatedAt
+					s.Klines[key] = kline
+					result.ModifiedCount++
+				}
+			}
+			continue
+		}
+		s.Klines[key] = kline
+		result.UpsertedCount++
+	}
+	return result, nil
+}
+
+func (s *MemorySink) LastOpenTime(ctx context.Context, symbol string, interval services.BinanceKlineInterval) (*time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var last *time.Time
+	for key, kline := range s.Klines {
+		if key.market != string(s.config.Binance.Market) || key.symbol != symbol || key.interval != string(interval) {
+			continue
+		}
+		if last == nil || kline.OpenTime.After(*last) {
+			openTime := kline.OpenTime
+			last = &openTime
+		}
+	}
+	return last, nil
+}
@@ -0,0 +1,612 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoDecimalKline is the document written for a kline with
+// storage.decimal, prices and volumes are stored as Decimal128.
+type mongoDecimalKline struct {
+	Market                   string               `bson:"market"`
+	Symbol                   string               `bson:"symbol"`
+	Interval                 string               `bson:"interval"`
+	OpenTime                 time.Time            `bson:"openTime"`
+	Open                     primitive.Decimal128 `bson:"open"`
+	High                     primitive.Decimal128 `bson:"high"`
+	Low                      primitive.Decimal128 `bson:"low"`
+	Close                    primitive.Decimal128 `bson:"close"`
+	Volume                   primitive.Decimal128 `bson:"volume"`
+	CloseTime                time.Time            `bson:"closeTime"`
+	QuoteAssetVolume         primitive.Decimal128 `bson:"quoteAssetVolume"`
+	NumberOfTrades           int64                `bson:"numberOfTrades"`
+	TakerBuyBaseAssetVolume  primitive.Decimal128 `bson:"takerBuyBaseAssetVolume"`
+	TakerBuyQuoteAssetVolume primitive.Decimal128 `bson:"takerBuyQuoteAssetVolume"`
+	CreatedAt                time.Time            `bson:"createdAt"`
+	UpdatedAt                time.Time            `bson:"updatedAt"`
+}
+
+func newMongoDecimalKline(k services.BinanceKline) (*mongoDecimalKline, error) {
+	d := k.DecimalStrings()
+	values := []string{
+		d.Open, d.High, d.Low, d.Close, d.Volume,
+		d.QuoteAssetVolume, d.TakerBuyBaseAssetVolume, d.TakerBuyQuoteAssetVolume,
+	}
+	var decimals [8]primitive.Decimal128
+	for i, v := range values {
+		decimal, err := primitive.ParseDecimal128(v)
+		if err != nil {
+			return nil, fmt.Errorf("kline %s %s %s: %v", k.Symbol, k.Interval, k.OpenTime.UTC().Format(time.RFC3339), err)
+		}
+		decimals[i] = decimal
+	}
+	return &mongoDecimalKline{
+		Market:                   k.Market,
+		Symbol:                   k.Symbol,
+		Interval:                 k.Interval,
+		OpenTime:                 k.OpenTime,
+		Open:                     decimals[0],
+		High:                     decimals[1],
+		Low:                      decimals[2],
+		Close:                    decimals[3],
+		Volume:                   decimals[4],
+		CloseTime:                k.CloseTime,
+		QuoteAssetVolume:         decimals[5],
+		NumberOfTrades:           k.NumberOfTrades,
+		TakerBuyBaseAssetVolume:  decimals[6],
+		TakerBuyQuoteAssetVolume: decimals[7],
+		CreatedAt:                k.CreatedAt,
+		UpdatedAt:                k.UpdatedAt,
+	}, nil
+}
+
+const MongoHistoryIndexName string = "symbol_at"
+
+// MongoSink stores symbols and klines in the MongoDB collections configured
+// under mongo.binance.
+type MongoSink struct {
+	mongo *services.Mongo
+}
+
+func NewMongoSink() *MongoSink {
+	return &MongoSink{mongo: services.GetMongo()}
+}
+
+func (s *MongoSink) Connect(ctx context.Context) error {
+	return s.mongo.Connect(ctx)
+}
+
+func (s *MongoSink) Close(ctx context.Context) error {
+	return s.mongo.Disconnect(ctx)
+}
+
+func (s *MongoSink) EnsureSchema(ctx context.Context) error {
+	var config = services.GetConfig()
+
+	// Ensure index on symbol collection
+	indexModel := mongo.IndexModel{
+		Keys: bson.D{
+			primitive.E{Key: "symbol", Value: 1},
+		},
+		Options: options.Index().SetUnique(true).SetName(config.Mongo.Binance.SymbolsIndexName),
+	}
+	err := s.ensureIndex(ctx, config.Mongo.Binance.SymbolsCollection, config.Mongo.Binance.SymbolsIndexName, indexModel)
+	if err != nil {
+		return err
+	}
+
+	// Ensure index on symbol history collection
+	indexModel = mongo.IndexModel{
+		Keys: bson.D{
+			primitive.E{Key: "symbol", Value: 1},
+			primitive.E{Key: "at", Value: 1},
+		},
+		Options: options.Index().SetName(MongoHistoryIndexName),
+	}
+	err = s.ensureIndex(ctx, s.historyCollection(), MongoHistoryIndexName, indexModel)
+	if err != nil {
+		return err
+	}
+
+	if config.Mongo.Binance.Timeseries {
+		return s.ensureTimeseries(ctx)
+	}
+
+	// Ensure index on klines collection
+	if err := s.ensureKlinesMarket(ctx, "market"); err != nil {
+		return err
+	}
+	indexModel = mongo.IndexModel{
+		Keys: bson.D{
+			primitive.E{Key: "market", Value: 1},
+			primitive.E{Key: "symbol", Value: 1},
+			primitive.E{Key: "interval", Value: 1},
+			primitive.E{Key: "openTime", Value: 1},
+		},
+		Options: options.Index().SetUnique(true).SetName(config.Mongo.Binance.KlinesIndexName),
+	}
+	return s.ensureIndex(ctx, config.Mongo.Binance.KlinesCollection, config.Mongo.Binance.KlinesIndexName, indexModel)
+}
+
+func (s *MongoSink) ensureIndex(ctx context.Context, col string, name string, model mongo.IndexModel) error {
+	log.Printf("ensureIndex: ensuring index %s...\n", name)
+	indexCreated, err := EnsureIndex(ctx, col, name, model)
+	if err != nil {
+		return err
+	}
+	if indexCreated == nil {
+		log.Println("ensureIndex: index already exists, do nothing.")
+	} else {
+		log.Println("ensureIndex: index created!")
+	}
+	return nil
+}
+
+// ensureKlinesMarket migrates a klines collection written before klines were
+// keyed by market. If the klines index lacks marketField it is dropped to be
+// recreated with it, and the klines with no market, all spot klines, get
+// one.
+func (s *MongoSink) ensureKlinesMarket(ctx context.Context, marketField string) error {
+	var config = services.GetConfig()
+	col := config.Mongo.Binance.KlinesCollection
+	name := config.Mongo.Binance.KlinesIndexName
+	indexes, err := s.mongo.ListIndexes(ctx, col)
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		fields := index.Map()
+		if fields["name"] != name {
+			continue
+		}
+		keys, _ := fields["key"].(primitive.D)
+		for _, key := range keys {
+			if key.Key == marketField {
+				return nil
+			}
+		}
+		log.Printf("ensureIndex: index %s has no %s key, recreating it...\n", name, marketField)
+		if err := s.mongo.DropIndex(ctx, col, name); err != nil {
+			return err
+		}
+	}
+	filter := bson.M{marketField: bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{marketField: string(services.MarketSpot)}}
+	result, err := s.mongo.UpdateMany(ctx, col, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("ensureIndex: set %s=%s on %d klines\n", marketField, services.MarketSpot, result.ModifiedCount)
+	}
+	return nil
+}
+
+// bulkWrite writes models in batches of mongo.bulkWrite.batchSize.
+func (s *MongoSink) bulkWrite(ctx context.Context, col string, models []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+	var config = services.GetConfig()
+	return s.mongo.BulkWriteBatches(ctx, col, models, config.Mongo.BulkWrite.BatchSize, *config.Mongo.BulkWrite.Ordered)
+}
+
+func mongoUpsertResult(result *mongo.BulkWriteResult) *UpsertResult {
+	if result == nil {
+		return nil
+	}
+	return &UpsertResult{
+		MatchedCount:  result.MatchedCount,
+		ModifiedCount: result.ModifiedCount,
+		UpsertedCount: result.UpsertedCount,
+	}
+}
+
+// mongoWriteError turns the write errors of a mongo.BulkWriteException into
+// a WriteError, describe returns the document of a write model index.
+func mongoWriteError(err error, describe func(i int) DocumentError) error {
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) {
+		return err
+	}
+	writeErr := &WriteError{}
+	for _, e := range bwe.WriteErrors {
+		doc := describe(e.Index)
+		doc.Code = e.Code
+		doc.Message = e.Message
+		writeErr.Documents = append(writeErr.Documents, doc)
+	}
+	if bwe.WriteConcernError != nil {
+		writeErr.Err = bwe.WriteConcernError
+	}
+	return writeErr
+}
+
+// historyCollection returns mongo.binance.historyCollection, defaulting to
+// the symbols collection suffixed with _history.
+func (s *MongoSink) historyCollection() string {
+	var config = services.GetConfig()
+	if config.Mongo.Binance.HistoryCollection != "" {
+		return config.Mongo.Binance.HistoryCollection
+	}
+	return config.Mongo.Binance.SymbolsCollection + "_history"
+}
+
+// seenCollection returns mongo.binance.seenCollection, defaulting to the
+// symbols collection suffixed with _seen.
+func (s *MongoSink) seenCollection() string {
+	var config = services.GetConfig()
+	if config.Mongo.Binance.SeenCollection != "" {
+		return config.Mongo.Binance.SeenCollection
+	}
+	return config.Mongo.Binance.SymbolsCollection + "_seen"
+}
+
+// seenSymbols returns every symbol exchangeInfo returned before.
+func (s *MongoSink) seenSymbols(ctx context.Context) (map[string]bool, error) {
+	cur, err := s.mongo.Cursor(ctx, s.seenCollection(), bson.M{}, options.Find().SetProjection(bson.M{"symbol": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		Symbol string `bson:"symbol"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, doc := range docs {
+		seen[doc.Symbol] = true
+	}
+	return seen, nil
+}
+
+// markSeen records the symbols of exchange not in seen along with
+// firstSeenAt.
+func (s *MongoSink) markSeen(ctx context.Context, exchange []services.BinanceSymbol, seen map[string]bool, now time.Time) error {
+	var names []string
+	var models []mongo.WriteModel
+	for _, symbol := range exchange {
+		if seen[symbol.Symbol] {
+			continue
+		}
+		upsert := mongo.NewUpdateOneModel()
+		upsert.SetFilter(bson.M{"symbol": symbol.Symbol})
+		upsert.SetUpdate(bson.M{"$setOnInsert": bson.M{"symbol": symbol.Symbol, "firstSeenAt": now}})
+		upsert.SetUpsert(true)
+		models = append(models, upsert)
+		names = append(names, symbol.Symbol)
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	_, err := s.bulkWrite(ctx, s.seenCollection(), models)
+	if err != nil {
+		return mongoWriteError(err, func(i int) DocumentError {
+			return DocumentError{Symbol: names[i]}
+		})
+	}
+	return nil
+}
+
+// TrackSymbols records lifecycle events on the stored symbols, status changes
+// set status and statusChangedAt and delistings set delisted and delistedAt,
+// and appends them to the history collection. The status is refreshed here as
+// symbols no longer selected are not upserted. Every exchange symbol is
+// recorded in the seen collection, listings are symbols missing from it.
+func (s *MongoSink) TrackSymbols(ctx context.Context, exchange []services.BinanceSymbol) ([]SymbolEvent, error) {
+	var config = services.GetConfig()
+	opts := options.Find().SetProjection(bson.M{"symbol": 1, "status": 1, "delisted": 1})
+	cur, err := s.mongo.Cursor(ctx, config.Mongo.Binance.SymbolsCollection, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		Symbol   string `bson:"symbol"`
+		Status   string `bson:"status"`
+		Delisted bool   `bson:"delisted"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	stored := map[string]StoredSymbolState{}
+	for _, doc := range docs {
+		stored[doc.Symbol] = StoredSymbolState{Status: doc.Status, Delisted: doc.Delisted}
+	}
+	now := time.Now()
+	seen, err := s.seenSymbols(ctx)
+	if err != nil {
+		return nil, err
+	}
+	events := DiffSymbols(stored, seen, exchange, now)
+	if len(events) == 0 {
+		return nil, s.markSeen(ctx, exchange, seen, now)
+	}
+
+	var updated []SymbolEvent
+	var updates []mongo.WriteModel
+	var inserts []mongo.WriteModel
+	for _, event := range events {
+		inserts = append(inserts, mongo.NewInsertOneModel().SetDocument(event))
+		var update bson.M
+		switch event.Type {
+		case SymbolStatusChanged:
+			update = bson.M{"$set": bson.M{"status": event.To, "statusChangedAt": event.At, "updatedAt": event.At}}
+		case SymbolRelisted:
+			update = bson.M{
+				"$set":   bson.M{"status": event.To, "delisted": false, "statusChangedAt": event.At, "updatedAt": event.At},
+				"$unset": bson.M{"delistedAt": ""},
+			}
+		case SymbolDelisted:
+			update = bson.M{"$set": bson.M{"delisted": true, "delistedAt": event.At, "updatedAt": event.At}}
+		default:
+			continue
+		}
+		updateOne := mongo.NewUpdateOneModel()
+		updateOne.SetFilter(bson.M{"symbol": event.Symbol})
+		updateOne.SetUpdate(update)
+		updates = append(updates, updateOne)
+		updated = append(updated, event)
+	}
+	if len(updates) > 0 {
+		if _, err := s.bulkWrite(ctx, config.Mongo.Binance.SymbolsCollection, updates); err != nil {
+			return nil, mongoWriteError(err, func(i int) DocumentError {
+				return DocumentError{Symbol: updated[i].Symbol}
+			})
+		}
+	}
+	if _, err := s.bulkWrite(ctx, s.historyCollection(), inserts); err != nil {
+		return nil, mongoWriteError(err, func(i int) DocumentError {
+			return DocumentError{Symbol: events[i].Symbol}
+		})
+	}
+	// Marked last so that listings are reported again if recording them fails
+	if err := s.markSeen(ctx, exchange, seen, now); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// mongoSymbolFields returns the fields of the symbol document except its
+// key, as bson.D so that embedded filters keep their field order and compare
+// equal to the stored ones.
+func mongoSymbolFields(symbol services.BinanceSymbol) (bson.D, error) {
+	content, err := bson.Marshal(symbol)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	var fields bson.D
+	for _, e := range doc {
+		if e.Key != "symbol" {
+			fields = append(fields, e)
+		}
+	}
+	return fields, nil
+}
+
+// UpsertSymbols inserts new symbols with $setOnInsert, a second bulk write
+// refreshes the metadata of stored symbols whose fields differ.
+func (s *MongoSink) UpsertSymbols(ctx context.Context, symbols []services.BinanceSymbol) (*UpsertResult, error) {
+	var config = services.GetConfig()
+	if len(symbols) == 0 {
+		return &UpsertResult{}, nil
+	}
+	now := time.Now()
+	var upserts []mongo.WriteModel
+	var updates []mongo.WriteModel
+	for _, symbol := range symbols {
+		fields, err := mongoSymbolFields(symbol)
+		if err != nil {
+			return nil, err
+		}
+		insert := bson.M{
+			"symbol":    symbol.Symbol,
+			"createdAt": now,
+			"updatedAt": now,
+		}
+		set := bson.M{"updatedAt": now}
+		var differ bson.A
+		for _, e := range fields {
+			insert[e.Key] = e.Value
+			set[e.Key] = e.Value
+			differ = append(differ, bson.M{e.Key: bson.M{"$ne": e.Value}})
+		}
+		updateOne := mongo.NewUpdateOneModel()
+		updateOne.SetFilter(bson.M{
+			"symbol": symbol.Symbol,
+		})
+		updateOne.SetUpdate(bson.M{
+			"$setOnInsert": insert,
+		})
+		updateOne.SetUpsert(true)
+		upserts = append(upserts, updateOne)
+
+		updateChanged := mongo.NewUpdateOneModel()
+		updateChanged.SetFilter(bson.M{
+			"symbol": symbol.Symbol,
+			"$or":    differ,
+		})
+		updateChanged.SetUpdate(bson.M{
+			"$set": set,
+		})
+		updates = append(updates, updateChanged)
+	}
+	describe := func(i int) DocumentError {
+		return DocumentError{Symbol: symbols[i].Symbol}
+	}
+	result, err := s.bulkWrite(ctx, config.Mongo.Binance.SymbolsCollection, upserts)
+	upsertResult := mongoUpsertResult(result)
+	if err != nil {
+		return upsertResult, mongoWriteError(err, describe)
+	}
+	result, err = s.bulkWrite(ctx, config.Mongo.Binance.SymbolsCollection, updates)
+	if result != nil {
+		upsertResult.ModifiedCount = result.ModifiedCount
+	}
+	if err != nil {
+		return upsertResult, mongoWriteError(err, describe)
+	}
+	return upsertResult, nil
+}
+
+// mongoKlineFields returns the fields of the kline document except its
+// key and timestamps, as Decimal128 with storage.decimal.
+func mongoKlineFields(kline services.BinanceKline, decimal bool) (bson.M, error) {
+	var doc interface{} = kline
+	if decimal {
+		decimalKline, err := newMongoDecimalKline(kline)
+		if err != nil {
+			return nil, err
+		}
+		doc = decimalKline
+	}
+	content, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var fields bson.M
+	if err := bson.Unmarshal(content, &fields); err != nil {
+		return nil, err
+	}
+	for _, key := range []string{"market", "symbol", "interval", "openTime", "createdAt", "updatedAt"} {
+		delete(fields, key)
+	}
+	return fields, nil
+}
+
+// UpsertKlines inserts new klines with $setOnInsert. With writeMode replace
+// stored klines are overwritten in the same bulk write, with writeMode update
+// a second bulk write overwrites only the stored klines whose fields differ.
+func (s *MongoSink) UpsertKlines(ctx context.Context, klines []services.BinanceKline) (*UpsertResult, error) {
+	var config = services.GetConfig()
+	if len(klines) == 0 {
+		return &UpsertResult{}, nil
+	}
+	if config.Mongo.Binance.Timeseries {
+		return s.upsertTimeseriesKlines(ctx, klines)
+	}
+	var upserts []mongo.WriteModel
+	var updates []mongo.WriteModel
+	for _, kline := range klines {
+		fields, err := mongoKlineFields(kline, config.Storage.Decimal)
+		if err != nil {
+			return nil, err
+		}
+		filter := bson.M{
+			"market":   kline.Market,
+			"symbol":   kline.Symbol,
+			"interval": kline.Interval,
+			"openTime": kline.OpenTime,
+		}
+		set := bson.M{"updatedAt": kline.UpdatedAt}
+		for key, value := range fields {
+			set[key] = value
+		}
+		updateOne := mongo.NewUpdateOneModel()
+		updateOne.SetFilter(filter)
+		updateOne.SetUpsert(true)
+		if config.Storage.WriteMode == services.WriteModeReplace {
+			updateOne.SetUpdate(bson.M{
+				"$set": set,
+				"$setOnInsert": bson.M{
+					"market":    kline.Market,
+					"symbol":    kline.Symbol,
+					"interval":  kline.Interval,
+					"openTime":  kline.OpenTime,
+					"createdAt": kline.CreatedAt,
+				},
+			})
+		} else {
+			// A copy, set is also the $set of the writeMode update write
+			// which must keep createdAt
+			insert := bson.M{
+				"market":    kline.Market,
+				"symbol":    kline.Symbol,
+				"interval":  kline.Interval,
+				"openTime":  kline.OpenTime,
+				"createdAt": kline.CreatedAt,
+			}
+			for key, value := range set {
+				insert[key] = value
+			}
+			updateOne.SetUpdate(bson.M{
+				"$setOnInsert": insert,
+			})
+		}
+		upserts = append(upserts, updateOne)
+
+		if config.Storage.WriteMode == services.WriteModeUpdate {
+			var differ bson.A
+			for key, value := range fields {
+				differ = append(differ, bson.M{key: bson.M{"$ne": value}})
+			}
+			changed := bson.M{"$or": differ}
+			for key, value := range filter {
+				changed[key] = value
+			}
+			updateChanged := mongo.NewUpdateOneModel()
+			updateChanged.SetFilter(changed)
+			updateChanged.SetUpdate(bson.M{
+				"$set": set,
+			})
+			updates = append(updates, updateChanged)
+		}
+	}
+	describe := func(i int) DocumentError {
+		openTime := klines[i].OpenTime
+		return DocumentError{Symbol: klines[i].Symbol, Interval: klines[i].Interval, OpenTime: &openTime}
+	}
+	result, err := s.bulkWrite(ctx, config.Mongo.Binance.KlinesCollection, upserts)
+	upsertResult := mongoUpsertResult(result)
+	if err != nil {
+		return upsertResult, mongoWriteError(err, describe)
+	}
+	if len(updates) > 0 {
+		result, err := s.bulkWrite(ctx, config.Mongo.Binance.KlinesCollection, updates)
+		if result != nil {
+			upsertResult.ModifiedCount = result.ModifiedCount
+		}
+		if err != nil {
+			return upsertResult, mongoWriteError(err, describe)
+		}
+	}
+	return upsertResult, nil
+}
+
+func (s *MongoSink) LastOpenTime(ctx context.Context, symbol string, interval services.BinanceKlineInterval) (*time.Time, error) {
+	var config = services.GetConfig()
+	filter := bson.M{
+		"market":   string(config.Binance.Market),
+		"symbol":   symbol,
+		"interval": string(interval),
+	}
+	if config.Mongo.Binance.Timeseries {
+		filter = bson.M{
+			MongoTimeseriesMetaField + ".market":   string(config.Binance.Market),
+			MongoTimeseriesMetaField + ".symbol":   symbol,
+			MongoTimeseriesMetaField + ".interval": string(interval),
+		}
+	}
+	opts := options.FindOne().
+		SetSort(bson.D{primitive.E{Key: "openTime", Value: -1}}).
+		SetProjection(bson.M{"openTime": 1})
+	var kline services.BinanceKline
+	err := s.mongo.FindOne(ctx, config.Mongo.Binance.KlinesCollection, filter, opts).Decode(&kline)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &kline.OpenTime, nil
+}
@@ -0,0 +1,284 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	MongoTimeseriesType      string = "timeseries"
+	MongoTimeseriesTimeField string = "openTime"
+	MongoTimeseriesMetaField string = "meta"
+)
+
+// timeseriesGranularity returns the granularity suited to the smallest of
+// intervals, they all share the klines collection.
+func timeseriesGranularity(intervals []services.KlinesInterval) string {
+	granularity := services.TimeseriesGranularityHours
+	for _, ki := range intervals {
+		if ki.Interval.TimeseriesGranularity() == services.TimeseriesGranularityMinutes {
+			granularity = services.TimeseriesGranularityMinutes
+		}
+	}
+	return granularity
+}
+
+// ensureTimeseries creates the klines collection as a time-series collection if
+// missing along with a secondary index on meta and openTime, meta holds the
+// market, symbol and interval. Time-series collections cannot have unique
+// indexes, an existing regular collection has to be migrated.
+func (s *MongoSink) ensureTimeseries(ctx context.Context) error {
+	var config = services.GetConfig()
+	col := config.Mongo.Binance.KlinesCollection
+	colType, err := s.mongo.CollectionType(ctx, col)
+	if err != nil {
+		return err
+	}
+	switch colType {
+	case "":
+		granularity := timeseriesGranularity(config.KlinesIntervals())
+		log.Printf("ensureTimeseries: creating time-series collection %s, granularity=%s...\n", col, granularity)
+		opts := options.CreateCollection().SetTimeSeriesOptions(options.TimeSeries().
+			SetTimeField(MongoTimeseriesTimeField).
+			SetMetaField(MongoTimeseriesMetaField).
+			SetGranularity(granularity))
+		if err := s.mongo.CreateCollection(ctx, col, opts); err != nil {
+			return err
+		}
+		log.Println("ensureTimeseries: collection created!")
+	case MongoTimeseriesType:
+		log.Println("ensureTimeseries: collection already exists, do nothing.")
+	default:
+		return fmt.Errorf("mongo: klines collection %s is not a time-series collection, rename it and copy it over with the migrate command", col)
+	}
+
+	if err := s.ensureKlinesMarket(ctx, MongoTimeseriesMetaField+".market"); err != nil {
+		return err
+	}
+	indexModel := mongo.IndexModel{
+		Keys: bson.D{
+			primitive.E{Key: MongoTimeseriesMetaField + ".market", Value: 1},
+			primitive.E{Key: MongoTimeseriesMetaField + ".symbol", Value: 1},
+			primitive.E{Key: MongoTimeseriesMetaField + ".interval", Value: 1},
+			primitive.E{Key: MongoTimeseriesTimeField, Value: 1},
+		},
+		Options: options.Index().SetName(config.Mongo.Binance.KlinesIndexName),
+	}
+	return s.ensureIndex(ctx, col, config.Mongo.Binance.KlinesIndexName, indexModel)
+}
+
+// timeseriesKlineDoc returns the time-series document of kline, market,
+// symbol and interval are moved under meta.
+func timeseriesKlineDoc(kline services.BinanceKline, decimal bool) (bson.M, error) {
+	doc, err := mongoKlineFields(kline, decimal)
+	if err != nil {
+		return nil, err
+	}
+	doc[MongoTimeseriesTimeField] = kline.OpenTime
+	doc[MongoTimeseriesMetaField] = bson.M{"market": kline.Market, "symbol": kline.Symbol, "interval": kline.Interval}
+	doc["createdAt"] = kline.CreatedAt
+	doc["updatedAt"] = kline.UpdatedAt
+	return doc, nil
+}
+
+type timeseriesKey struct {
+	market   string
+	symbol   string
+	interval string
+}
+
+// timeseriesDocKey returns the meta and openTime of a time-series document.
+func timeseriesDocKey(doc bson.M) (timeseriesKey, time.Time) {
+	var key timeseriesKey
+	switch meta := doc[MongoTimeseriesMetaField].(type) {
+	case bson.M:
+		key.market, _ = meta["market"].(string)
+		key.symbol, _ = meta["symbol"].(string)
+		key.interval, _ = meta["interval"].(string)
+	}
+	var openTime time.Time
+	switch t := doc[MongoTimeseriesTimeField].(type) {
+	case time.Time:
+		openTime = t
+	case primitive.DateTime:
+		openTime = t.Time()
+	}
+	return key, openTime
+}
+
+// insertTimeseries inserts the documents not stored yet into the time-series
+// klines collection. With no unique index to rely on, the openTimes stored
+// for every symbol and interval in docs are looked up first, documents
+// matching one are counted as matched.
+func (s *MongoSink) insertTimeseries(ctx context.Context, docs []bson.M) (*UpsertResult, error) {
+	var config = services.GetConfig()
+	col := config.Mongo.Binance.KlinesCollection
+	type openTimeRange struct {
+		min, max time.Time
+	}
+	ranges := map[timeseriesKey]*openTimeRange{}
+	for _, doc := range docs {
+		key, openTime := timeseriesDocKey(doc)
+		r, ok := ranges[key]
+		if !ok {
+			ranges[key] = &openTimeRange{min: openTime, max: openTime}
+			continue
+		}
+		if openTime.Before(r.min) {
+			r.min = openTime
+		}
+		if openTime.After(r.max) {
+			r.max = openTime
+		}
+	}
+
+	stored := map[timeseriesKey]map[int64]bool{}
+	for key, r := range ranges {
+		filter := bson.M{
+			MongoTimeseriesMetaField + ".market":   key.market,
+			MongoTimeseriesMetaField + ".symbol":   key.symbol,
+			MongoTimeseriesMetaField + ".interval": key.interval,
+			MongoTimeseriesTimeField:               bson.M{"$gte": r.min, "$lte": r.max},
+		}
+		cur, err := s.mongo.Cursor(ctx, col, filter, options.Find().SetProjection(bson.M{MongoTimeseriesTimeField: 1}))
+		if err != nil {
+			return nil, err
+		}
+		var found []struct {
+			OpenTime time.Time `bson:"openTime"`
+		}
+		if err := cur.All(ctx, &found); err != nil {
+			return nil, err
+		}
+		stored[key] = map[int64]bool{}
+		for _, f := range found {
+			stored[key][f.OpenTime.UnixMilli()] = true
+		}
+	}
+
+	result := &UpsertResult{}
+	var pending []bson.M
+	var models []mongo.WriteModel
+	for _, doc := range docs {
+		key, openTime := timeseriesDocKey(doc)
+		if stored[key][openTime.UnixMilli()] {
+			result.MatchedCount++
+			continue
+		}
+		stored[key][openTime.UnixMilli()] = true
+		pending = append(pending, doc)
+		models = append(models, mongo.NewInsertOneModel().SetDocument(doc))
+	}
+	if len(models) == 0 {
+		return result, nil
+	}
+	written, err := s.bulkWrite(ctx, col, models)
+	if written != nil {
+		result.UpsertedCount = written.InsertedCount
+	}
+	if err != nil {
+		return result, mongoWriteError(err, func(i int) DocumentError {
+			key, openTime := timeseriesDocKey(pending[i])
+			return DocumentError{Symbol: key.symbol, Interval: key.interval, OpenTime: &openTime}
+		})
+	}
+	return result, nil
+}
+
+// upsertTimeseriesKlines inserts the klines not stored yet into the
+// time-series klines collection.
+func (s *MongoSink) upsertTimeseriesKlines(ctx context.Context, klines []services.BinanceKline) (*UpsertResult, error) {
+	var config = services.GetConfig()
+	var docs []bson.M
+	for _, kline := range klines {
+		doc, err := timeseriesKlineDoc(kline, config.Storage.Decimal)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return s.insertTimeseries(ctx, docs)
+}
+
+// MigrateTimeseries copies the klines of the regular collection from into
+// the time-series klines collection, in batches of mongo.bulkWrite.batchSize.
+// Klines with no market are copied as spot klines. Klines already copied
+// are skipped, so an interrupted migration can be run again. write is called
+// with the result of every batch, the number of documents read is returned.
+func MigrateTimeseries(ctx context.Context, sink *MongoSink, from string, write func(result *UpsertResult)) (int64, error) {
+	var config = services.GetConfig()
+	if from == config.Mongo.Binance.KlinesCollection {
+		return 0, NewScopeError(AppMigrate, ExitConfig, fmt.Errorf("migrate: source and target are both %s", from))
+	}
+	batchSize := config.Mongo.BulkWrite.BatchSize
+	if batchSize <= 0 {
+		batchSize = services.MongoBulkWriteBatchSize
+	}
+	// The source index may predate market, a sort it cannot serve would hit
+	// the in-memory sort limit on large collections
+	opts := options.Find().SetSort(bson.D{
+		primitive.E{Key: "market", Value: 1},
+		primitive.E{Key: "symbol", Value: 1},
+		primitive.E{Key: "interval", Value: 1},
+		primitive.E{Key: "openTime", Value: 1},
+	}).SetAllowDiskUse(true)
+	cur, err := sink.mongo.Cursor(ctx, from, bson.M{}, opts)
+	if err != nil {
+		return 0, NewScopeError(AppMigrate, ExitStorage, err)
+	}
+	defer cur.Close(context.Background())
+
+	read := int64(0)
+	var docs []bson.M
+	flush := func() error {
+		if len(docs) == 0 {
+			return nil
+		}
+		result, err := sink.insertTimeseries(ctx, docs)
+		if result != nil {
+			write(result)
+		}
+		docs = nil
+		return NewScopeError(AppBulkWrite, ExitStorage, err)
+	}
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err != nil {
+			return read, NewScopeError(AppMigrate, ExitStorage, err)
+		}
+		read++
+		market, ok := doc["market"]
+		if !ok {
+			market = string(services.MarketSpot)
+		}
+		doc[MongoTimeseriesMetaField] = bson.M{"market": market, "symbol": doc["symbol"], "interval": doc["interval"]}
+		delete(doc, "_id")
+		delete(doc, "market")
+		delete(doc, "symbol")
+		delete(doc, "interval")
+		docs = append(docs, doc)
+		if len(docs) >= batchSize {
+			if err := flush(); err != nil {
+				return read, err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		if ctx.Err() != nil {
+			return read, ctx.Err()
+		}
+		return read, NewScopeError(AppMigrate, ExitStorage, err)
+	}
+	if ctx.Err() != nil {
+		return read, ctx.Err()
+	}
+	return read, flush()
+}
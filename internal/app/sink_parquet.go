@@ -0,0 +1,383 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	ParquetManifestFile    string = "_manifest.json"
+	ParquetRowsPerFile     int    = 100000
+	ParquetMaxBufferedRows int    = 1000000
+	ParquetWriterThreads   int64  = 4
+)
+
+type parquetKline struct {
+	Market                   string  `parquet:"name=market, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Symbol                   string  `parquet:"name=symbol, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Interval                 string  `parquet:"name=interval, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	OpenTime                 int64   `parquet:"name=open_time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Open                     float64 `parquet:"name=open, type=DOUBLE"`
+	High                     float64 `parquet:"name=high, type=DOUBLE"`
+	Low                      float64 `parquet:"name=low, type=DOUBLE"`
+	Close                    float64 `parquet:"name=close, type=DOUBLE"`
+	Volume                   float64 `parquet:"name=volume, type=DOUBLE"`
+	CloseTime                int64   `parquet:"name=close_time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	QuoteAssetVolume         float64 `parquet:"name=quote_asset_volume, type=DOUBLE"`
+	NumberOfTrades           int64   `parquet:"name=number_of_trades, type=INT64"`
+	TakerBuyBaseAssetVolume  float64 `parquet:"name=taker_buy_base_asset_volume, type=DOUBLE"`
+	TakerBuyQuoteAssetVolume float64 `parquet:"name=taker_buy_quote_asset_volume, type=DOUBLE"`
+}
+
+// ParquetManifestEntry records a written part file and the klines it holds.
+type ParquetManifestEntry struct {
+	Path        string    `json:"path"`
+	Symbol      string    `json:"symbol"`
+	Interval    string    `json:"interval"`
+	Year        int       `json:"year"`
+	Rows        int64     `json:"rows"`
+	MinOpenTime time.Time `json:"minOpenTime"`
+	MaxOpenTime time.Time `json:"maxOpenTime"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// ParquetManifestSymbol is the latest metadata of a symbol.
+type ParquetManifestSymbol struct {
+	Info      services.BinanceSymbol `json:"info"`
+	UpdatedAt time.Time              `json:"updatedAt"`
+}
+
+// ParquetManifest lists every part file written under the parquet dir along
+// with the symbols seen, when each was first seen and its latest metadata. It
+// is rewritten after every flushed part.
+type ParquetManifest struct {
+	Symbols    map[string]time.Time             `json:"symbols"`
+	SymbolInfo map[string]ParquetManifestSymbol `json:"symbolInfo,omitempty"`
+	Files      []ParquetManifestEntry           `json:"files"`
+}
+
+type parquetPartition struct {
+	symbol   string
+	interval string
+	year     int
+}
+
+// ParquetSink writes klines into a Hive-style layout
+// interval=1h/symbol=BTCUSDT/year=2024/part-*.parquet, futures markets are
+// written under a subdir of parquet.dir named after the market. Rows are
+// buffered per partition and flushed into a new part file once rowsPerFile
+// is reached, once the job dumping its symbol and interval is done, once a
+// newer year starts for its symbol and interval, or on Close. The largest
+// partitions are flushed first when more than maxBufferedRows rows are
+// buffered overall. Existing part files are never rewritten. Prices
+// and volumes are always DOUBLE columns, storage.decimal does not apply.
+type ParquetSink struct {
+	mu              sync.Mutex
+	dir             string
+	rowsPerFile     int
+	maxBufferedRows int
+	buffered        int
+	manifest        ParquetManifest
+	buffers         map[parquetPartition][]services.BinanceKline
+	last            map[string]time.Time
+	seq             int
+}
+
+func NewParquetSink() *ParquetSink {
+	return &ParquetSink{}
+}
+
+func parquetLastKey(symbol string, interval string) string {
+	return symbol + "|" + interval
+}
+
+func (s *ParquetSink) Connect(ctx context.Context) error {
+	var config = services.GetConfig()
+	s.dir = config.Parquet.Dir
+	s.rowsPerFile = config.Parquet.RowsPerFile
+	if s.rowsPerFile <= 0 {
+		s.rowsPerFile = ParquetRowsPerFile
+	}
+	s.maxBufferedRows = config.Parquet.MaxBufferedRows
+	if s.maxBufferedRows <= 0 {
+		s.maxBufferedRows = ParquetMaxBufferedRows
+	}
+	s.buffered = 0
+	s.buffers = map[parquetPartition][]services.BinanceKline{}
+	s.last = map[string]time.Time{}
+	s.manifest = ParquetManifest{Symbols: map[string]time.Time{}, SymbolInfo: map[string]ParquetManifestSymbol{}}
+
+	content, err := ioutil.ReadFile(filepath.Join(s.dir, ParquetManifestFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, &s.manifest); err != nil {
+		return err
+	}
+	if s.manifest.Symbols == nil {
+		s.manifest.Symbols = map[string]time.Time{}
+	}
+	if s.manifest.SymbolInfo == nil {
+		s.manifest.SymbolInfo = map[string]ParquetManifestSymbol{}
+	}
+	for _, entry := range s.manifest.Files {
+		key := parquetLastKey(entry.Symbol, entry.Interval)
+		if last, ok := s.last[key]; !ok || entry.MaxOpenTime.After(last) {
+			s.last[key] = entry.MaxOpenTime
+		}
+	}
+	return nil
+}
+
+// Close flushes every buffered partition.
+func (s *ParquetSink) Close(ctx context.Context) error {
+	return s.Flush(ctx)
+}
+
+// Flush writes every buffered partition into a new part file.
+func (s *ParquetSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for partition := range s.buffers {
+		if err := s.flush(partition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FlushKlines writes the buffered partitions of symbol and interval into new
+// part files.
+func (s *ParquetSink) FlushKlines(ctx context.Context, symbol string, interval services.BinanceKlineInterval) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for partition := range s.buffers {
+		if partition.symbol == symbol && partition.interval == string(interval) {
+			if err := s.flush(partition); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ParquetSink) EnsureSchema(ctx context.Context) error {
+	return os.MkdirAll(s.dir, 0755)
+}
+
+// UpsertSymbols records new symbols and the metadata of every symbol in the
+// manifest, which is only rewritten when something changed.
+func (s *ParquetSink) UpsertSymbols(ctx context.Context, symbols []services.BinanceSymbol) (*UpsertResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := &UpsertResult{}
+	now := time.Now()
+	changed := false
+	for _, symbol := range symbols {
+		info, ok := s.manifest.SymbolInfo[symbol.Symbol]
+		if _, seen := s.manifest.Symbols[symbol.Symbol]; seen {
+			result.MatchedCount++
+			if ok && info.Info.Equal(symbol) {
+				continue
+			}
+			result.ModifiedCount++
+		} else {
+			s.manifest.Symbols[symbol.Symbol] = now
+			result.UpsertedCount++
+		}
+		s.manifest.SymbolInfo[symbol.Symbol] = ParquetManifestSymbol{Info: symbol, UpdatedAt: now}
+		changed = true
+	}
+	if changed {
+		if err := s.writeManifest(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// UpsertKlines buffers klines newer than the last openTime written for their
+// symbol and interval, older ones are counted as matched. Klines come in
+// openTime order, so the partitions of earlier years of a symbol and interval
+// are flushed once a newer year starts.
+func (s *ParquetSink) UpsertKlines(ctx context.Context, klines []services.BinanceKline) (*UpsertResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := &UpsertResult{}
+	for _, kline := range klines {
+		key := parquetLastKey(kline.Symbol, kline.Interval)
+		if last, ok := s.last[key]; ok && !kline.OpenTime.After(last) {
+			result.MatchedCount++
+			continue
+		}
+		s.last[key] = kline.OpenTime
+		partition := parquetPartition{
+			symbol:   kline.Symbol,
+			interval: kline.Interval,
+			year:     kline.OpenTime.UTC().Year(),
+		}
+		if _, ok := s.buffers[partition]; !ok {
+			if err := s.flushEarlierYears(partition); err != nil {
+				return nil, err
+			}
+		}
+		s.buffers[partition] = append(s.buffers[partition], kline)
+		s.buffered++
+		result.UpsertedCount++
+		if len(s.buffers[partition]) >= s.rowsPerFile {
+			if err := s.flush(partition); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.flushLargest(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// flushEarlierYears flushes the partitions of the symbol and interval of
+// partition buffered for a year before its year.
+func (s *ParquetSink) flushEarlierYears(partition parquetPartition) error {
+	for buffered := range s.buffers {
+		if buffered.symbol == partition.symbol && buffered.interval == partition.interval && buffered.year < partition.year {
+			if err := s.flush(buffered); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flushLargest flushes the largest partitions until no more than
+// maxBufferedRows rows are buffered.
+func (s *ParquetSink) flushLargest() error {
+	for s.buffered > s.maxBufferedRows {
+		var largest parquetPartition
+		rows := 0
+		for partition, klines := range s.buffers {
+			if len(klines) > rows {
+				largest, rows = partition, len(klines)
+			}
+		}
+		if err := s.flush(largest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ParquetSink) LastOpenTime(ctx context.Context, symbol string, interval services.BinanceKlineInterval) (*time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last, ok := s.last[parquetLastKey(symbol, string(interval))]
+	if !ok {
+		return nil, nil
+	}
+	return &last, nil
+}
+
+// flush writes the buffered klines of partition into a new part file and
+// records it in the manifest.
+func (s *ParquetSink) flush(partition parquetPartition) error {
+	klines := s.buffers[partition]
+	delete(s.buffers, partition)
+	s.buffered -= len(klines)
+	if len(klines) == 0 {
+		return nil
+	}
+	now := time.Now()
+	s.seq++
+	relDir := filepath.Join(
+		"interval="+partition.interval,
+		"symbol="+partition.symbol,
+		fmt.Sprintf("year=%d", partition.year),
+	)
+	relPath := filepath.Join(relDir, fmt.Sprintf("part-%d-%04d.parquet", now.UnixMilli(), s.seq))
+	if err := os.MkdirAll(filepath.Join(s.dir, relDir), 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, relPath)
+	if err := writeParquetKlines(path+".tmp", klines); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	s.manifest.Files = append(s.manifest.Files, ParquetManifestEntry{
+		Path:        filepath.ToSlash(relPath),
+		Symbol:      partition.symbol,
+		Interval:    partition.interval,
+		Year:        partition.year,
+		Rows:        int64(len(klines)),
+		MinOpenTime: klines[0].OpenTime,
+		MaxOpenTime: klines[len(klines)-1].OpenTime,
+		CreatedAt:   now,
+	})
+	return s.writeManifest()
+}
+
+func (s *ParquetSink) writeManifest() error {
+	content, err := json.MarshalIndent(s.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, ParquetManifestFile)
+	if err := ioutil.WriteFile(path+".tmp", content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func writeParquetKlines(path string, klines []services.BinanceKline) error {
+	fw, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		return err
+	}
+	pw, err := writer.NewParquetWriter(fw, new(parquetKline), ParquetWriterThreads)
+	if err != nil {
+		fw.Close()
+		return err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	for _, k := range klines {
+		err = pw.Write(parquetKline{
+			Market:                   k.Market,
+			Symbol:                   k.Symbol,
+			Interval:                 k.Interval,
+			OpenTime:                 k.OpenTime.UnixMilli(),
+			Open:                     k.Open,
+			High:                     k.High,
+			Low:                      k.Low,
+			Close:                    k.Close,
+			Volume:                   k.Volume,
+			CloseTime:                k.CloseTime.UnixMilli(),
+			QuoteAssetVolume:         k.QuoteAssetVolume,
+			NumberOfTrades:           k.NumberOfTrades,
+			TakerBuyBaseAssetVolume:  k.TakerBuyBaseAssetVolume,
+			TakerBuyQuoteAssetVolume: k.TakerBuyQuoteAssetVolume,
+		})
+		if err != nil {
+			fw.Close()
+			return err
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		fw.Close()
+		return err
+	}
+	return fw.Close()
+}
@@ -0,0 +1,244 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+)
+
+// PostgresInsertBatchSize keeps multi-row inserts under the 65535 bind
+// parameter limit of the Postgres protocol.
+const PostgresInsertBatchSize int = 1000
+
+// PostgresSink stores symbols and klines in PostgreSQL, optionally turning
+// the klines table into a TimescaleDB hypertable on open_time.
+type PostgresSink struct {
+	postgres *services.Postgres
+	config   *services.Config
+}
+
+func NewPostgresSink(config *services.Config) *PostgresSink {
+	return &PostgresSink{postgres: services.GetPostgres(), config: config}
+}
+
+func (s *PostgresSink) Connect(ctx context.Context) error {
+	return s.postgres.Connect(ctx, s.config.Postgres.URL)
+}
+
+func (s *PostgresSink) Close(ctx context.Context) error {
+	return s.postgres.Close()
+}
+
+func (s *PostgresSink) EnsureSchema(ctx context.Context) error {
+	var config = s.config
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			symbol TEXT NOT NULL PRIMARY KEY,
+			metadata JSONB,
+			created_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL
+		)`, config.Postgres.SymbolsTable),
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS metadata JSONB`, config.Postgres.SymbolsTable),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			market TEXT NOT NULL DEFAULT 'spot',
+			symbol TEXT NOT NULL,
+			interval TEXT NOT NULL,
+			open_time TIMESTAMPTZ NOT NULL,
+			open NUMERIC NOT NULL,
+			high NUMERIC NOT NULL,
+			low NUMERIC NOT NULL,
+			close NUMERIC NOT NULL,
+			volume NUMERIC NOT NULL,
+			close_time TIMESTAMPTZ NOT NULL,
+			quote_asset_volume NUMERIC NOT NULL,
+			number_of_trades BIGINT NOT NULL,
+			taker_buy_base_asset_volume NUMERIC NOT NULL,
+			taker_buy_quote_asset_volume NUMERIC NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL
+		)`, config.Postgres.KlinesTable),
+		// Klines stored before they were keyed by market are spot klines
+		fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS market TEXT NOT NULL DEFAULT 'spot'`, config.Postgres.KlinesTable),
+	}
+	if config.Postgres.Timescale {
+		statements = append(statements, fmt.Sprintf(
+			`SELECT create_hypertable('%s', 'open_time', if_not_exists => TRUE, migrate_data => TRUE)`,
+			config.Postgres.KlinesTable,
+		))
+	}
+	for _, statement := range statements {
+		if _, err := s.postgres.DB().ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return s.ensureKlinesIndex(ctx)
+}
+
+// ensureKlinesIndex creates the unique index on market, symbol, interval and
+// open_time, replacing the index of the same name without market.
+func (s *PostgresSink) ensureKlinesIndex(ctx context.Context) error {
+	var config = s.config
+	var definition string
+	err := s.postgres.DB().QueryRowContext(ctx, `SELECT indexdef FROM pg_indexes WHERE indexname = $1`,
+		config.Postgres.KlinesIndexName).Scan(&definition)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	case !strings.Contains(definition, "market"):
+		if _, err := s.postgres.DB().ExecContext(ctx, fmt.Sprintf(`DROP INDEX %s`, config.Postgres.KlinesIndexName)); err != nil {
+			return err
+		}
+	}
+	_, err = s.postgres.DB().ExecContext(ctx, fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (market, symbol, interval, open_time)`,
+		config.Postgres.KlinesIndexName, config.Postgres.KlinesTable))
+	return err
+}
+
+// insertOnConflict inserts rows with multi-row INSERT ... ON CONFLICT
+// statements in a single transaction, conflicting rows are counted as
+// matched. onConflict is the conflict action, DO NOTHING when empty, rows it
+// updates are counted as modified.
+func (s *PostgresSink) insertOnConflict(ctx context.Context, table string, columns []string, rows [][]interface{}, onConflict string) (*UpsertResult, error) {
+	result := &UpsertResult{}
+	if len(rows) == 0 {
+		return result, nil
+	}
+	if onConflict == "" {
+		onConflict = "DO NOTHING"
+	}
+	tx, err := s.postgres.DB().BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	for start := 0; start < len(rows); start += PostgresInsertBatchSize {
+		end := start + PostgresInsertBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		var values []string
+		var args []interface{}
+		for _, row := range rows[start:end] {
+			var placeholders []string
+			for _, v := range row {
+				args = append(args, v)
+				placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+			}
+			values = append(values, "("+strings.Join(placeholders, ", ")+")")
+		}
+		// xmax is 0 for freshly inserted rows and set for updated ones
+		insert := fmt.Sprintf(`INSERT INTO %s AS t (%s) VALUES %s ON CONFLICT %s RETURNING (t.xmax = 0)`,
+			table, strings.Join(columns, ", "), strings.Join(values, ", "), onConflict)
+		inserted, modified, err := s.countReturning(ctx, tx, insert, args)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		result.UpsertedCount += inserted
+		result.ModifiedCount += modified
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	result.MatchedCount = int64(len(rows)) - result.UpsertedCount
+	return result, nil
+}
+
+// countReturning runs query and counts the rows it returns as inserted or
+// modified.
+func (s *PostgresSink) countReturning(ctx context.Context, tx *sql.Tx, query string, args []interface{}) (int64, int64, error) {
+	res, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer res.Close()
+	var inserted, modified int64
+	for res.Next() {
+		var isInsert bool
+		if err := res.Scan(&isInsert); err != nil {
+			return 0, 0, err
+		}
+		if isInsert {
+			inserted++
+		} else {
+			modified++
+		}
+	}
+	return inserted, modified, res.Err()
+}
+
+// UpsertSymbols stores the symbol metadata as JSONB, rewriting it along with
+// updated_at only when it changed.
+func (s *PostgresSink) UpsertSymbols(ctx context.Context, symbols []services.BinanceSymbol) (*UpsertResult, error) {
+	var config = s.config
+	now := time.Now()
+	var rows [][]interface{}
+	for _, symbol := range symbols {
+		metadata, err := json.Marshal(symbol)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, []interface{}{symbol.Symbol, string(metadata), now, now})
+	}
+	columns := []string{"symbol", "metadata", "created_at", "updated_at"}
+	onConflict := `(symbol) DO UPDATE SET metadata = EXCLUDED.metadata, updated_at = EXCLUDED.updated_at
+		WHERE t.metadata IS DISTINCT FROM EXCLUDED.metadata`
+	return s.insertOnConflict(ctx, config.Postgres.SymbolsTable, columns, rows, onConflict)
+}
+
+func (s *PostgresSink) UpsertKlines(ctx context.Context, klines []services.BinanceKline) (*UpsertResult, error) {
+	var config = s.config
+	var rows [][]interface{}
+	for _, k := range klines {
+		v := klineDecimalValues(k, config.Storage.Decimal)
+		rows = append(rows, []interface{}{
+			k.Symbol, k.Interval, k.OpenTime,
+			v[0], v[1], v[2], v[3], v[4],
+			k.CloseTime, v[5], k.NumberOfTrades,
+			v[6], v[7],
+			k.CreatedAt, k.UpdatedAt,
+			k.Market,
+		})
+	}
+	columns := []string{
+		"symbol", "interval", "open_time", "open", "high", "low", "close", "volume",
+		"close_time", "quote_asset_volume", "number_of_trades",
+		"taker_buy_base_asset_volume", "taker_buy_quote_asset_volume",
+		"created_at", "updated_at", "market",
+	}
+	var onConflict string
+	switch config.Storage.WriteMode {
+	case services.WriteModeReplace, services.WriteModeUpdate:
+		values := columns[3:13]
+		var set, excluded, stored []string
+		for _, column := range values {
+			set = append(set, column+" = EXCLUDED."+column)
+			excluded = append(excluded, "EXCLUDED."+column)
+			stored = append(stored, "t."+column)
+		}
+		set = append(set, "updated_at = EXCLUDED.updated_at")
+		onConflict = "(market, symbol, interval, open_time) DO UPDATE SET " + strings.Join(set, ", ")
+		if config.Storage.WriteMode == services.WriteModeUpdate {
+			onConflict += fmt.Sprintf(" WHERE (%s) IS DISTINCT FROM (%s)", strings.Join(stored, ", "), strings.Join(excluded, ", "))
+		}
+	}
+	return s.insertOnConflict(ctx, config.Postgres.KlinesTable, columns, rows, onConflict)
+}
+
+func (s *PostgresSink) LastOpenTime(ctx context.Context, symbol string, interval services.BinanceKlineInterval) (*time.Time, error) {
+	var config = s.config
+	query := fmt.Sprintf(`SELECT MAX(open_time) FROM %s WHERE market = $1 AND symbol = $2 AND interval = $3`, config.Postgres.KlinesTable)
+	var openTime sql.NullTime
+	err := s.postgres.DB().QueryRowContext(ctx, query, string(config.Binance.Market), symbol, string(interval)).Scan(&openTime)
+	if err != nil {
+		return nil, err
+	}
+	if !openTime.Valid {
+		return nil, nil
+	}
+	return &openTime.Time, nil
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+)
+
+// PostgresTestURLEnv names the database the Postgres integration test runs
+// against, the test is skipped when unset. Its tables are dropped afterwards.
+const PostgresTestURLEnv string = "PAIRDUMP_TEST_POSTGRES_URL"
+
+func TestPostgresSink(t *testing.T) {
+	url := os.Getenv(PostgresTestURLEnv)
+	if url == "" {
+		t.Skipf("%s not set", PostgresTestURLEnv)
+	}
+	suffix := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	config := &services.Config{}
+	config.Binance.Market = services.MarketSpot
+	config.Storage.WriteMode = services.WriteModeInsert
+	config.Postgres.URL = url
+	config.Postgres.SymbolsTable = "binance_symbols_" + suffix
+	config.Postgres.KlinesTable = "binance_klines_" + suffix
+	config.Postgres.KlinesIndexName = "symbol_interval_openTime_" + suffix
+
+	ctx := context.Background()
+	sink := NewPostgresSink(config)
+	if err := sink.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close(ctx)
+	defer func() {
+		for _, table := range []string{config.Postgres.SymbolsTable, config.Postgres.KlinesTable} {
+			if _, err := sink.postgres.DB().ExecContext(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	t.Run("EnsureSchema", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if err := sink.EnsureSchema(ctx); err != nil {
+				t.Fatalf("run %d: %v", i+1, err)
+			}
+		}
+	})
+
+	checkResult := func(t *testing.T, result *UpsertResult, err error, upserted, matched, modified int64) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.UpsertedCount != upserted || result.MatchedCount != matched || result.ModifiedCount != modified {
+			t.Errorf("upserted/matched/modified = %d/%d/%d, want %d/%d/%d",
+				result.UpsertedCount, result.MatchedCount, result.ModifiedCount, upserted, matched, modified)
+		}
+	}
+
+	t.Run("UpsertSymbols", func(t *testing.T) {
+		symbols := []services.BinanceSymbol{{Symbol: "BTCUSDT", Status: "TRADING"}, {Symbol: "ETHUSDT", Status: "TRADING"}}
+		result, err := sink.UpsertSymbols(ctx, symbols)
+		checkResult(t, result, err, 2, 0, 0)
+		result, err = sink.UpsertSymbols(ctx, symbols)
+		checkResult(t, result, err, 0, 2, 0)
+		symbols[1].Status = "BREAK"
+		result, err = sink.UpsertSymbols(ctx, symbols)
+		checkResult(t, result, err, 0, 2, 1)
+	})
+
+	klines := testKlines("BTCUSDT", 3)
+	t.Run("UpsertKlines", func(t *testing.T) {
+		result, err := sink.UpsertKlines(ctx, klines)
+		checkResult(t, result, err, 3, 0, 0)
+		// insert keeps stored klines
+		result, err = sink.UpsertKlines(ctx, klines)
+		checkResult(t, result, err, 0, 3, 0)
+
+		changed := append([]services.BinanceKline{}, klines...)
+		changed[2].Close = 2
+		result, err = sink.UpsertKlines(ctx, changed)
+		checkResult(t, result, err, 0, 3, 0)
+
+		config.Storage.WriteMode = services.WriteModeUpdate
+		defer func() { config.Storage.WriteMode = services.WriteModeInsert }()
+		result, err = sink.UpsertKlines(ctx, changed)
+		checkResult(t, result, err, 0, 3, 1)
+		result, err = sink.UpsertKlines(ctx, changed)
+		checkResult(t, result, err, 0, 3, 0)
+
+		config.Storage.WriteMode = services.WriteModeReplace
+		result, err = sink.UpsertKlines(ctx, klines)
+		checkResult(t, result, err, 0, 3, 3)
+	})
+
+	t.Run("LastOpenTime", func(t *testing.T) {
+		last, err := sink.LastOpenTime(ctx, "BTCUSDT", services.OneHour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := klines[len(klines)-1].OpenTime; last == nil || !last.Equal(want) {
+			t.Errorf("last openTime = %v, want %v", last, want)
+		}
+		for _, tt := range []struct {
+			name     string
+			market   services.BinanceMarket
+			symbol   string
+			interval services.BinanceKlineInterval
+		}{
+			{"other symbol", services.MarketSpot, "ETHUSDT", services.OneHour},
+			{"other interval", services.MarketSpot, "BTCUSDT", services.OneDay},
+			{"other market", services.MarketUSDM, "BTCUSDT", services.OneHour},
+		} {
+			config.Binance.Market = tt.market
+			last, err := sink.LastOpenTime(ctx, tt.symbol, tt.interval)
+			config.Binance.Market = services.MarketSpot
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last != nil {
+				t.Errorf("%s: last openTime = %v, want none", tt.name, last)
+			}
+		}
+	})
+}
@@ -0,0 +1,256 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/atton16/go-pair-dump/internal/services"
+)
+
+// SQLiteSink stores symbols and klines in an embedded SQLite database. Times
+// are stored as unix milliseconds, like the Binance API returns them. With
+// storage.decimal prices and volumes are stored as TEXT holding the exact
+// decimal strings, SQLite has no exact decimal type. The column type is fixed
+// when the table is created.
+type SQLiteSink struct {
+	sqlite *services.SQLite
+}
+
+func NewSQLiteSink() *SQLiteSink {
+	return &SQLiteSink{sqlite: services.GetSQLite()}
+}
+
+func (s *SQLiteSink) Connect(ctx context.Context) error {
+	return s.sqlite.Connect(ctx)
+}
+
+func (s *SQLiteSink) Close(ctx context.Context) error {
+	return s.sqlite.Close()
+}
+
+func (s *SQLiteSink) EnsureSchema(ctx context.Context) error {
+	var config = services.GetConfig()
+	decimal := "REAL"
+	if config.Storage.Decimal {
+		decimal = "TEXT"
+	}
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			symbol TEXT NOT NULL PRIMARY KEY,
+			metadata TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL
+		)`, config.SQLite.SymbolsTable),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+			market TEXT NOT NULL DEFAULT 'spot',
+			symbol TEXT NOT NULL,
+			interval TEXT NOT NULL,
+			open_time INTEGER NOT NULL,
+			open %[2]s NOT NULL,
+			high %[2]s NOT NULL,
+			low %[2]s NOT NULL,
+			close %[2]s NOT NULL,
+			volume %[2]s NOT NULL,
+			close_time INTEGER NOT NULL,
+			quote_asset_volume %[2]s NOT NULL,
+			number_of_trades INTEGER NOT NULL,
+			taker_buy_base_asset_volume %[2]s NOT NULL,
+			taker_buy_quote_asset_volume %[2]s NOT NULL,
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL
+		)`, config.SQLite.KlinesTable, decimal),
+	}
+	for _, statement := range statements {
+		if _, err := s.sqlite.DB().ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	if err := s.ensureColumn(ctx, config.SQLite.SymbolsTable, "metadata", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	// Klines stored before they were keyed by market are spot klines
+	if err := s.ensureColumn(ctx, config.SQLite.KlinesTable, "market", `TEXT NOT NULL DEFAULT 'spot'`); err != nil {
+		return err
+	}
+	return s.ensureKlinesIndex(ctx)
+}
+
+// ensureKlinesIndex creates the unique index on market, symbol, interval and
+// open_time, replacing the index of the same name without market.
+func (s *SQLiteSink) ensureKlinesIndex(ctx context.Context) error {
+	var config = services.GetConfig()
+	var definition string
+	err := s.sqlite.DB().QueryRowContext(ctx, `SELECT sql FROM sqlite_master WHERE type = 'index' AND name = ?`,
+		config.SQLite.KlinesIndexName).Scan(&definition)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	case !strings.Contains(definition, "market"):
+		if _, err := s.sqlite.DB().ExecContext(ctx, fmt.Sprintf(`DROP INDEX %s`, config.SQLite.KlinesIndexName)); err != nil {
+			return err
+		}
+	}
+	_, err = s.sqlite.DB().ExecContext(ctx, fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (market, symbol, interval, open_time)`,
+		config.SQLite.KlinesIndexName, config.SQLite.KlinesTable))
+	return err
+}
+
+// ensureColumn adds column to tables created before it existed.
+func (s *SQLiteSink) ensureColumn(ctx context.Context, table string, column string, definition string) error {
+	rows, err := s.sqlite.DB().QueryContext(ctx, fmt.Sprintf(`SELECT name FROM pragma_table_info('%s')`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = s.sqlite.DB().ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
+
+// insertIgnore runs insert once per row in a single transaction, rows
+// conflicting with the unique key are counted as matched. When update is set
+// it runs with the same row for every matched row, counting the rows it
+// changes as modified.
+func (s *SQLiteSink) insertIgnore(ctx context.Context, insert string, update string, rows [][]interface{}) (*UpsertResult, error) {
+	result := &UpsertResult{}
+	if len(rows) == 0 {
+		return result, nil
+	}
+	tx, err := s.sqlite.DB().BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := tx.PrepareContext(ctx, insert)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	defer stmt.Close()
+	var updateStmt *sql.Stmt
+	if update != "" {
+		updateStmt, err = tx.PrepareContext(ctx, update)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		defer updateStmt.Close()
+	}
+	exec := func(stmt *sql.Stmt, row []interface{}) (int64, error) {
+		res, err := stmt.ExecContext(ctx, row...)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	}
+	for _, row := range rows {
+		n, err := exec(stmt, row)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		result.UpsertedCount += n
+		if n > 0 || updateStmt == nil {
+			continue
+		}
+		n, err = exec(updateStmt, row)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		result.ModifiedCount += n
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	result.MatchedCount = int64(len(rows)) - result.UpsertedCount
+	return result, nil
+}
+
+// UpsertSymbols stores the symbol metadata as JSON, rewriting it along with
+// updated_at only when it changed.
+func (s *SQLiteSink) UpsertSymbols(ctx context.Context, symbols []services.BinanceSymbol) (*UpsertResult, error) {
+	var config = services.GetConfig()
+	now := time.Now().UnixMilli()
+	var rows [][]interface{}
+	for _, symbol := range symbols {
+		metadata, err := json.Marshal(symbol)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, []interface{}{symbol.Symbol, string(metadata), now, now})
+	}
+	insert := fmt.Sprintf(`INSERT OR IGNORE INTO %s (symbol, metadata, created_at, updated_at) VALUES (?, ?, ?, ?)`, config.SQLite.SymbolsTable)
+	update := fmt.Sprintf(`UPDATE %s SET metadata = ?2, updated_at = ?4 WHERE symbol = ?1 AND metadata IS NOT ?2`, config.SQLite.SymbolsTable)
+	return s.insertIgnore(ctx, insert, update, rows)
+}
+
+func (s *SQLiteSink) UpsertKlines(ctx context.Context, klines []services.BinanceKline) (*UpsertResult, error) {
+	var config = services.GetConfig()
+	var rows [][]interface{}
+	for _, k := range klines {
+		v := klineDecimalValues(k, config.Storage.Decimal)
+		rows = append(rows, []interface{}{
+			k.Symbol, k.Interval, k.OpenTime.UnixMilli(),
+			v[0], v[1], v[2], v[3], v[4],
+			k.CloseTime.UnixMilli(), v[5], k.NumberOfTrades,
+			v[6], v[7],
+			k.CreatedAt.UnixMilli(), k.UpdatedAt.UnixMilli(),
+			k.Market,
+		})
+	}
+	insert := fmt.Sprintf(`INSERT OR IGNORE INTO %s (
+		symbol, interval, open_time, open, high, low, close, volume,
+		close_time, quote_asset_volume, number_of_trades,
+		taker_buy_base_asset_volume, taker_buy_quote_asset_volume,
+		created_at, updated_at, market
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, config.SQLite.KlinesTable)
+	// The update binds the insert row by position, ?14 (created_at) is kept
+	var update string
+	switch config.Storage.WriteMode {
+	case services.WriteModeReplace, services.WriteModeUpdate:
+		update = fmt.Sprintf(`UPDATE %s SET
+			open = ?4, high = ?5, low = ?6, close = ?7, volume = ?8,
+			close_time = ?9, quote_asset_volume = ?10, number_of_trades = ?11,
+			taker_buy_base_asset_volume = ?12, taker_buy_quote_asset_volume = ?13,
+			updated_at = ?15
+		WHERE market = ?16 AND symbol = ?1 AND interval = ?2 AND open_time = ?3`, config.SQLite.KlinesTable)
+	}
+	if config.Storage.WriteMode == services.WriteModeUpdate {
+		update += ` AND (
+			open IS NOT ?4 OR high IS NOT ?5 OR low IS NOT ?6 OR close IS NOT ?7 OR volume IS NOT ?8 OR
+			close_time IS NOT ?9 OR quote_asset_volume IS NOT ?10 OR number_of_trades IS NOT ?11 OR
+			taker_buy_base_asset_volume IS NOT ?12 OR taker_buy_quote_asset_volume IS NOT ?13
+		)`
+	}
+	return s.insertIgnore(ctx, insert, update, rows)
+}
+
+func (s *SQLiteSink) LastOpenTime(ctx context.Context, symbol string, interval services.BinanceKlineInterval) (*time.Time, error) {
+	var config = services.GetConfig()
+	query := fmt.Sprintf(`SELECT MAX(open_time) FROM %s WHERE market = ? AND symbol = ? AND interval = ?`, config.SQLite.KlinesTable)
+	var openTime sql.NullInt64
+	err := s.sqlite.DB().QueryRowContext(ctx, query, string(config.Binance.Market), symbol, string(interval)).Scan(&openTime)
+	if err != nil {
+		return nil, err
+	}
+	if !openTime.Valid {
+		return nil, nil
+	}
+	t := time.UnixMilli(openTime.Int64)
+	return &t, nil
+}
@@ -9,8 +9,24 @@ import (
 var argsOnce sync.Once
 var myArgs *Args
 
+type ImportCmd struct {
+	Dir          string `arg:"positional,required" help:"directory of Binance public data kline zip files"`
+	SkipChecksum bool   `arg:"--skip-checksum" help:"do not verify .CHECKSUM files"`
+}
+
+type MigrateCmd struct {
+	From string `arg:"positional,required" help:"regular klines collection to copy into the time-series klines collection"`
+}
+
+type ListSymbolsCmd struct {
+	JSON bool `arg:"--json" help:"print the symbol metadata as JSON Lines"`
+}
+
 type Args struct {
-	Config string `arg:"-c" default:"./pairdump.yaml" help:"config file (.yaml)"`
+	Config      string          `arg:"-c" default:"./pairdump.yaml" help:"config file (.yaml)"`
+	Import      *ImportCmd      `arg:"subcommand:import" help:"import klines from Binance public data zip files"`
+	Migrate     *MigrateCmd     `arg:"subcommand:migrate" help:"copy a regular mongo klines collection into the time-series one"`
+	ListSymbols *ListSymbolsCmd `arg:"subcommand:list-symbols" help:"print the symbols the selector resolves to and exit"`
 }
 
 func GetArgs() *Args {
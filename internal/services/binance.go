@@ -1,13 +1,17 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"path"
+	"reflect"
 	"strconv"
 	"sync"
 	"time"
@@ -21,10 +25,25 @@ var myBinance *Binance
 const (
 	ExchangeInfoPath string = "/api/v3/exchangeInfo"
 	KlinesPath       string = "/api/v3/klines"
+	Ticker24hrPath   string = "/api/v3/ticker/24hr"
+	// KlinesMaxLimit is the largest spot klines page.
+	KlinesMaxLimit int = 1000
+)
+
+const (
+	BinanceRetryBaseDelay time.Duration = 500 * time.Millisecond
+	BinanceRetryAttempts  int           = 5
+	BinanceRetryMaxDelay  time.Duration = 30 * time.Second
+	BinanceHTTPTimeout    time.Duration = 30 * time.Second
 )
 
 type Binance struct {
-	apiURL string
+	apiURL        string
+	market        BinanceMarket
+	client        *http.Client
+	limiter       *BinanceRateLimiter
+	retryAttempts int
+	retryMaxDelay time.Duration
 }
 
 type BinanceKlineInterval string
@@ -47,119 +66,419 @@ const (
 	OneMonth       BinanceKlineInterval = "1M"
 )
 
+var binanceKlineIntervals = []BinanceKlineInterval{
+	OneMinute, ThreeMinutes, FiveMinutes, FifteenMinutes, ThirtyMinutes,
+	OneHour, TwoHours, FourHours, SixHours, EightHours, TwelveHours,
+	OneDay, ThreeDays, OneWeek, OneMonth,
+}
+
+func (i BinanceKlineInterval) IsValid() bool {
+	for _, v := range binanceKlineIntervals {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	TimeseriesGranularityMinutes string = "minutes"
+	TimeseriesGranularityHours   string = "hours"
+)
+
+// TimeseriesGranularity is the MongoDB time-series collection granularity
+// suited to klines of the interval.
+func (i BinanceKlineInterval) TimeseriesGranularity() string {
+	switch i {
+	case OneMinute, ThreeMinutes, FiveMinutes, FifteenMinutes, ThirtyMinutes:
+		return TimeseriesGranularityMinutes
+	}
+	return TimeseriesGranularityHours
+}
+
 type BinanceKlinesOptions struct {
 	StartTime *int64
 	EndTime   *int64
 	Limit     *int
 }
 
+type BinanceRateLimit struct {
+	RateLimitType string `json:"rateLimitType"`
+	Interval      string `json:"interval"`
+	IntervalNum   int32  `json:"intervalNum"`
+	Limit         int32  `json:"limit"`
+}
+
 type BinanceExchangeInfo struct {
-	Timezone   string `json:"timezone"`
-	ServerTime int64  `json:"serverTime"`
-	RateLimits []struct {
-		RateLimitType string `json:"rateLimitType"`
-		Interval      string `json:"interval"`
-		IntervalNum   int32  `json:"intervalNum"`
-		Limit         int32  `json:"limit"`
-	} `json:"rateLimits"`
-	ExchangeFilters []interface{} `json:"exchangeFilters"`
-	Symbols         []struct {
-		Symbol                     string   `json:"symbol"`
-		Status                     string   `json:"status"`
-		BaseAsset                  string   `json:"baseAsset"`
-		BaseAssetPrecision         int8     `json:"baseAssetPrecision"`
-		QuoteAsset                 string   `json:"quoteAsset"`
-		QuotePrecision             int8     `json:"quotePrecision"`
-		QuoteAssetPrecision        int8     `json:"quoteAssetPrecision"`
-		BaseCommissionPrecision    int8     `json:"baseCommissionPrecision"`
-		QuoteCommissionPrecision   int8     `json:"quoteCommissionPrecision"`
-		OrderTypes                 []string `json:"orderTypes"`
-		IcebergAllowed             bool     `json:"icebergAllowed"`
-		OcoAllowed                 bool     `json:"ocoAllowed"`
-		QuoteOrderQtyMarketAllowed bool     `json:"quoteOrderQtyMarketAllowed"`
-		IsSpotTradingAllowed       bool     `json:"isSpotTradingAllowed"`
-		IsMarginTradingAllowed     bool     `json:"isMarginTradingAllowed"`
-		Filters                    []struct {
-			FilterType string `json:"filterType"`
-			// filterType: PRICE_FILTER
-			MinPrice string `json:"minPrice,omitempty"`
-			MaxPrice string `json:"maxPrice,omitempty"`
-			TickSize string `json:"tickSize,omitempty"`
-			// filterType: PERCENT_PRICE
-			MultiplierUp   string `json:"multiplierUp,omitempty"`
-			MultiplierDown string `json:"multiplierDown,omitempty"`
-			// filterType: PERCENT_PRICE, MIN_NOTIONAL
-			AvgPriceMins int8 `json:"avgPriceMins,omitempty"`
-			// filterType: LOT_SIZE, MARKET_LOT_SIZE
-			MinQty   string `json:"minQty,omitempty"`
-			MaxQty   string `json:"maxQty,omitempty"`
-			StepSize string `json:"stepSize,omitempty"`
-			// filterType: MIN_NOTIONAL
-			MinNotional   string `json:"minNotional,omitempty"`
-			ApplyToMarket bool   `json:"applyToMarket,omitempty"`
-			// filterType: ICEBERG_PARTS
-			Limit int8 `json:"limit,omitempty"`
-			// filterType: MAX_NUM_ORDERS
-			MaxNumOrders int16 `json:"maxNumOrders,omitempty"`
-			// filterType: MAX_NUM_ALGO_ORDERS
-			MaxNumAlgoOrders int16 `json:"maxNumAlgoOrders,omitempty"`
-		} `json:"filters"`
-		Permissions []string `json:"permissions"`
-	} `json:"symbols"`
+	Timezone        string             `json:"timezone"`
+	ServerTime      int64              `json:"serverTime"`
+	RateLimits      []BinanceRateLimit `json:"rateLimits"`
+	ExchangeFilters []interface{}      `json:"exchangeFilters"`
+	Symbols         []BinanceSymbol    `json:"symbols"`
+}
+
+// BinanceSymbol is the exchangeInfo metadata of a symbol.
+type BinanceSymbol struct {
+	Symbol                     string                `json:"symbol" bson:"symbol"`
+	Status                     string                `json:"status" bson:"status"`
+	BaseAsset                  string                `json:"baseAsset" bson:"baseAsset"`
+	BaseAssetPrecision         int8                  `json:"baseAssetPrecision" bson:"baseAssetPrecision"`
+	QuoteAsset                 string                `json:"quoteAsset" bson:"quoteAsset"`
+	QuotePrecision             int8                  `json:"quotePrecision" bson:"quotePrecision"`
+	QuoteAssetPrecision        int8                  `json:"quoteAssetPrecision" bson:"quoteAssetPrecision"`
+	BaseCommissionPrecision    int8                  `json:"baseCommissionPrecision" bson:"baseCommissionPrecision"`
+	QuoteCommissionPrecision   int8                  `json:"quoteCommissionPrecision" bson:"quoteCommissionPrecision"`
+	OrderTypes                 []string              `json:"orderTypes" bson:"orderTypes"`
+	IcebergAllowed             bool                  `json:"icebergAllowed" bson:"icebergAllowed"`
+	OcoAllowed                 bool                  `json:"ocoAllowed" bson:"ocoAllowed"`
+	QuoteOrderQtyMarketAllowed bool                  `json:"quoteOrderQtyMarketAllowed" bson:"quoteOrderQtyMarketAllowed"`
+	IsSpotTradingAllowed       bool                  `json:"isSpotTradingAllowed" bson:"isSpotTradingAllowed"`
+	IsMarginTradingAllowed     bool                  `json:"isMarginTradingAllowed" bson:"isMarginTradingAllowed"`
+	Filters                    []BinanceSymbolFilter `json:"filters" bson:"filters"`
+	Permissions                []string              `json:"permissions" bson:"permissions"`
+	PermissionSets             [][]string            `json:"permissionSets,omitempty" bson:"permissionSets,omitempty"`
+	Contract                   *BinanceContract      `json:"contract,omitempty" bson:"contract,omitempty"`
+}
+
+const (
+	FilterPrice       string = "PRICE_FILTER"
+	FilterLotSize     string = "LOT_SIZE"
+	FilterMinNotional string = "MIN_NOTIONAL"
+	FilterNotional    string = "NOTIONAL"
+)
+
+type BinanceSymbolFilter struct {
+	FilterType string `json:"filterType" bson:"filterType"`
+	// filterType: PRICE_FILTER
+	MinPrice string `json:"minPrice,omitempty" bson:"minPrice,omitempty"`
+	MaxPrice string `json:"maxPrice,omitempty" bson:"maxPrice,omitempty"`
+	TickSize string `json:"tickSize,omitempty" bson:"tickSize,omitempty"`
+	// filterType: PERCENT_PRICE
+	MultiplierUp   string `json:"multiplierUp,omitempty" bson:"multiplierUp,omitempty"`
+	MultiplierDown string `json:"multiplierDown,omitempty" bson:"multiplierDown,omitempty"`
+	// filterType: PERCENT_PRICE, MIN_NOTIONAL, NOTIONAL
+	AvgPriceMins int8 `json:"avgPriceMins,omitempty" bson:"avgPriceMins,omitempty"`
+	// filterType: LOT_SIZE, MARKET_LOT_SIZE
+	MinQty   string `json:"minQty,omitempty" bson:"minQty,omitempty"`
+	MaxQty   string `json:"maxQty,omitempty" bson:"maxQty,omitempty"`
+	StepSize string `json:"stepSize,omitempty" bson:"stepSize,omitempty"`
+	// filterType: MIN_NOTIONAL, NOTIONAL
+	MinNotional   string `json:"minNotional,omitempty" bson:"minNotional,omitempty"`
+	ApplyToMarket bool   `json:"applyToMarket,omitempty" bson:"applyToMarket,omitempty"`
+	// filterType: MIN_NOTIONAL on USD-M futures, parsed into minNotional
+	Notional string `json:"notional,omitempty" bson:"notional,omitempty"`
+	// filterType: NOTIONAL
+	MaxNotional      string `json:"maxNotional,omitempty" bson:"maxNotional,omitempty"`
+	ApplyMinToMarket bool   `json:"applyMinToMarket,omitempty" bson:"applyMinToMarket,omitempty"`
+	ApplyMaxToMarket bool   `json:"applyMaxToMarket,omitempty" bson:"applyMaxToMarket,omitempty"`
+	// filterType: ICEBERG_PARTS, and MAX_NUM_ORDERS / MAX_NUM_ALGO_ORDERS on
+	// futures
+	Limit int `json:"limit,omitempty" bson:"limit,omitempty"`
+	// filterType: MAX_NUM_ORDERS
+	MaxNumOrders int `json:"maxNumOrders,omitempty" bson:"maxNumOrders,omitempty"`
+	// filterType: MAX_NUM_ALGO_ORDERS
+	MaxNumAlgoOrders int `json:"maxNumAlgoOrders,omitempty" bson:"maxNumAlgoOrders,omitempty"`
+}
+
+// Filter returns the filter of filterType, or nil if the symbol has none.
+func (s *BinanceSymbol) Filter(filterType string) *BinanceSymbolFilter {
+	for i := range s.Filters {
+		if s.Filters[i].FilterType == filterType {
+			return &s.Filters[i]
+		}
+	}
+	return nil
+}
+
+// filterValue parses field of the filterType filter, ok is false when the
+// filter or field is missing.
+func (s *BinanceSymbol) filterValue(filterType string, field func(f *BinanceSymbolFilter) string) (float64, bool, error) {
+	f := s.Filter(filterType)
+	if f == nil || field(f) == "" {
+		return 0, false, nil
+	}
+	v, err := strconv.ParseFloat(field(f), 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("binance: symbol %s: invalid %s: %v", s.Symbol, filterType, err)
+	}
+	return v, true, nil
+}
+
+// TickSize returns the price step of the PRICE_FILTER filter.
+func (s *BinanceSymbol) TickSize() (float64, bool, error) {
+	return s.filterValue(FilterPrice, func(f *BinanceSymbolFilter) string { return f.TickSize })
+}
+
+// StepSize returns the quantity step of the LOT_SIZE filter.
+func (s *BinanceSymbol) StepSize() (float64, bool, error) {
+	return s.filterValue(FilterLotSize, func(f *BinanceSymbolFilter) string { return f.StepSize })
+}
+
+// MinNotional returns the min notional of the MIN_NOTIONAL filter, or of the
+// NOTIONAL filter that replaces it on newer symbols.
+func (s *BinanceSymbol) MinNotional() (float64, bool, error) {
+	minNotional := func(f *BinanceSymbolFilter) string { return f.MinNotional }
+	if v, ok, err := s.filterValue(FilterMinNotional, minNotional); ok || err != nil {
+		return v, ok, err
+	}
+	return s.filterValue(FilterNotional, minNotional)
+}
+
+// BinanceTicker24hr is the rolling 24h statistics of a symbol, COIN-M
+// reports baseVolume instead of quoteVolume.
+type BinanceTicker24hr struct {
+	Symbol             string `json:"symbol"`
+	PriceChange        string `json:"priceChange"`
+	PriceChangePercent string `json:"priceChangePercent"`
+	WeightedAvgPrice   string `json:"weightedAvgPrice"`
+	LastPrice          string `json:"lastPrice"`
+	OpenPrice          string `json:"openPrice"`
+	HighPrice          string `json:"highPrice"`
+	LowPrice           string `json:"lowPrice"`
+	Volume             string `json:"volume"`
+	QuoteVolume        string `json:"quoteVolume"`
+	BaseVolume         string `json:"baseVolume,omitempty"`
+	OpenTime           int64  `json:"openTime"`
+	CloseTime          int64  `json:"closeTime"`
+	Count              int64  `json:"count"`
+}
+
+// QuoteVolumeValue parses the 24h volume in quote asset. COIN-M tickers only
+// report the volume in contracts, which is converted with contractSize.
+func (t *BinanceTicker24hr) QuoteVolumeValue(contractSize int64) (float64, error) {
+	if t.QuoteVolume == "" && contractSize > 0 {
+		v, err := strconv.ParseFloat(t.Volume, 64)
+		if err != nil {
+			return 0, fmt.Errorf("binance: ticker %s: invalid volume: %v", t.Symbol, err)
+		}
+		return v * float64(contractSize), nil
+	}
+	v, err := strconv.ParseFloat(t.QuoteVolume, 64)
+	if err != nil {
+		return 0, fmt.Errorf("binance: ticker %s: invalid quoteVolume: %v", t.Symbol, err)
+	}
+	return v, nil
+}
+
+// HasPermission reports whether the symbol has permission, e.g. SPOT or
+// MARGIN, in permissions, in one of the permissionSets that replace them or
+// through the isSpotTradingAllowed and isMarginTradingAllowed flags.
+func (s *BinanceSymbol) HasPermission(permission string) bool {
+	switch permission {
+	case "SPOT":
+		if s.IsSpotTradingAllowed {
+			return true
+		}
+	case "MARGIN":
+		if s.IsMarginTradingAllowed {
+			return true
+		}
+	}
+	for _, p := range s.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	for _, set := range s.PermissionSets {
+		for _, p := range set {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Equal reports whether s and other hold the same metadata.
+func (s *BinanceSymbol) Equal(other BinanceSymbol) bool {
+	return reflect.DeepEqual(*s, other)
+}
+
+// BinanceKlineDecimals keeps the exact decimal strings Binance returned for
+// the price and volume fields, which float64 cannot always represent.
+type BinanceKlineDecimals struct {
+	Open                     string
+	High                     string
+	Low                      string
+	Close                    string
+	Volume                   string
+	QuoteAssetVolume         string
+	TakerBuyBaseAssetVolume  string
+	TakerBuyQuoteAssetVolume string
 }
 
 type BinanceKline struct {
-	Symbol                   string    `bson:"symbol"`
-	Interval                 string    `bson:"interval"`
-	OpenTime                 time.Time `bson:"openTime"`
-	Open                     float64   `bson:"open"`
-	High                     float64   `bson:"high"`
-	Low                      float64   `bson:"low"`
-	Close                    float64   `bson:"close"`
-	Volume                   float64   `bson:"volume"`
-	CloseTime                time.Time `bson:"closeTime"`
-	QuoteAssetVolume         float64   `bson:"quoteAssetVolume"`
-	NumberOfTrades           int64     `bson:"numberOfTrades"`
-	TakerBuyBaseAssetVolume  float64   `bson:"takerBuyBaseAssetVolume"`
-	TakerBuyQuoteAssetVolume float64   `bson:"takerBuyQuoteAssetVolume"`
-	CreatedAt                time.Time `bson:"createdAt"`
-	UpdatedAt                time.Time `bson:"updatedAt"`
+	Market                   string               `bson:"market"`
+	Symbol                   string               `bson:"symbol"`
+	Interval                 string               `bson:"interval"`
+	OpenTime                 time.Time            `bson:"openTime"`
+	Open                     float64              `bson:"open"`
+	High                     float64              `bson:"high"`
+	Low                      float64              `bson:"low"`
+	Close                    float64              `bson:"close"`
+	Volume                   float64              `bson:"volume"`
+	CloseTime                time.Time            `bson:"closeTime"`
+	QuoteAssetVolume         float64              `bson:"quoteAssetVolume"`
+	NumberOfTrades           int64                `bson:"numberOfTrades"`
+	TakerBuyBaseAssetVolume  float64              `bson:"takerBuyBaseAssetVolume"`
+	TakerBuyQuoteAssetVolume float64              `bson:"takerBuyQuoteAssetVolume"`
+	CreatedAt                time.Time            `bson:"createdAt"`
+	UpdatedAt                time.Time            `bson:"updatedAt"`
+	Decimals                 BinanceKlineDecimals `bson:"-"`
 	// Ignore                   string    `bson:"ignore"`
 }
 
+// SameValues reports whether k and other hold the same open, high, low,
+// close, volumes, closeTime and number of trades.
+func (k *BinanceKline) SameValues(other BinanceKline) bool {
+	return k.DecimalStrings() == other.DecimalStrings() &&
+		k.CloseTime.Equal(other.CloseTime) &&
+		k.NumberOfTrades == other.NumberOfTrades
+}
+
+// DecimalStrings returns the exact decimal strings of the kline, fields
+// without one are formatted from their float64 value.
+func (k *BinanceKline) DecimalStrings() BinanceKlineDecimals {
+	d := k.Decimals
+	f := func(s *string, v float64) {
+		if *s == "" {
+			*s = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	f(&d.Open, k.Open)
+	f(&d.High, k.High)
+	f(&d.Low, k.Low)
+	f(&d.Close, k.Close)
+	f(&d.Volume, k.Volume)
+	f(&d.QuoteAssetVolume, k.QuoteAssetVolume)
+	f(&d.TakerBuyBaseAssetVolume, k.TakerBuyBaseAssetVolume)
+	f(&d.TakerBuyQuoteAssetVolume, k.TakerBuyQuoteAssetVolume)
+	return d
+}
+
+// binanceKlineJSON decodes numbers as json.Number, keeping them exact.
+var binanceKlineJSON = jsoniter.Config{UseNumber: true}.Froze()
+
+// binanceKlineIntColumns are the kline columns sent as JSON numbers: open
+// time, close time and number of trades. Prices and volumes are strings.
+var binanceKlineIntColumns = map[int]bool{0: true, 6: true, 8: true}
+
+// UnmarshalJSON parses a kline array. Numbers are only accepted in the
+// integer columns, a number in a price or volume column is rejected rather
+// than rounded.
 func (k *BinanceKline) UnmarshalJSON(bs []byte) error {
-	var json = jsoniter.ConfigCompatibleWithStandardLibrary
 	arr := []interface{}{}
-	err := json.Unmarshal(bs, &arr)
+	err := binanceKlineJSON.Unmarshal(bs, &arr)
+	if err != nil {
+		return err
+	}
+	record := make([]string, len(arr))
+	for i, v := range arr {
+		switch v := v.(type) {
+		case string:
+			record[i] = v
+		case json.Number:
+			if !binanceKlineIntColumns[i] {
+				return fmt.Errorf("kline: column %d: expected a string, got number %s", i, v)
+			}
+			record[i] = v.String()
+		default:
+			return fmt.Errorf("kline: column %d: unexpected value %v", i, v)
+		}
+	}
+	return k.parseRecord(record)
+}
+
+// ParseBinanceKlineRecord parses a row of a Binance public data kline CSV
+// file, which has the same 12-column layout as the /api/v3/klines arrays.
+func ParseBinanceKlineRecord(record []string) (BinanceKline, error) {
+	var k BinanceKline
+	err := k.parseRecord(record)
+	return k, err
+}
+
+// parseRecord strictly parses the 12-column kline layout, malformed fields
+// are returned as errors. Open and close times in microseconds, as used by
+// spot public data files since 2025, are converted to milliseconds.
+func (k *BinanceKline) parseRecord(record []string) error {
+	if len(record) < 12 {
+		return fmt.Errorf("kline: expected 12 columns, got %d", len(record))
+	}
+	var err error
+	parseInt := func(col int) int64 {
+		if err != nil {
+			return 0
+		}
+		var v int64
+		v, err = strconv.ParseInt(record[col], 10, 64)
+		if err != nil {
+			err = fmt.Errorf("kline: column %d: %v", col, err)
+		}
+		return v
+	}
+	parseDecimal := func(col int, dst *string) float64 {
+		if err != nil {
+			return 0
+		}
+		var v float64
+		v, err = strconv.ParseFloat(record[col], 64)
+		if err != nil {
+			err = fmt.Errorf("kline: column %d: %v", col, err)
+		}
+		*dst = record[col]
+		return v
+	}
+	openTime := parseInt(0)
+	k.Open = parseDecimal(1, &k.Decimals.Open)
+	k.High = parseDecimal(2, &k.Decimals.High)
+	k.Low = parseDecimal(3, &k.Decimals.Low)
+	k.Close = parseDecimal(4, &k.Decimals.Close)
+	k.Volume = parseDecimal(5, &k.Decimals.Volume)
+	closeTime := parseInt(6)
+	k.QuoteAssetVolume = parseDecimal(7, &k.Decimals.QuoteAssetVolume)
+	k.NumberOfTrades = parseInt(8)
+	k.TakerBuyBaseAssetVolume = parseDecimal(9, &k.Decimals.TakerBuyBaseAssetVolume)
+	k.TakerBuyQuoteAssetVolume = parseDecimal(10, &k.Decimals.TakerBuyQuoteAssetVolume)
 	if err != nil {
 		return err
 	}
-	k.OpenTime = time.UnixMilli(int64(arr[0].(float64)))
-	k.Open, _ = strconv.ParseFloat(arr[1].(string), 64)
-	k.High, _ = strconv.ParseFloat(arr[2].(string), 64)
-	k.Low, _ = strconv.ParseFloat(arr[3].(string), 64)
-	k.Close, _ = strconv.ParseFloat(arr[4].(string), 64)
-	k.Volume, _ = strconv.ParseFloat(arr[5].(string), 64)
-	k.CloseTime = time.UnixMilli(int64(arr[6].(float64)))
-	k.QuoteAssetVolume, _ = strconv.ParseFloat(arr[7].(string), 64)
-	k.NumberOfTrades = int64(arr[8].(float64))
-	k.TakerBuyBaseAssetVolume, _ = strconv.ParseFloat(arr[9].(string), 64)
-	k.TakerBuyQuoteAssetVolume, _ = strconv.ParseFloat(arr[10].(string), 64)
+	if openTime > 1e14 {
+		openTime /= 1000
+		closeTime /= 1000
+	}
+	k.OpenTime = time.UnixMilli(openTime)
+	k.CloseTime = time.UnixMilli(closeTime)
 	now := time.Now()
 	k.CreatedAt = now
 	k.UpdatedAt = now
-	// k.Ignore = arr[11].(string)
+	// k.Ignore = record[11]
 	return nil
 }
 
 func GetBinance() *Binance {
 	binanceOnce.Do(func() {
 		config := GetConfig()
-		if _, err := url.Parse(config.Binance.ApiURL); err != nil {
-			log.Fatalf("error: %v", err)
+		timeout := BinanceHTTPTimeout
+		if config.Binance.HTTP.Timeout > 0 {
+			timeout = time.Duration(config.Binance.HTTP.Timeout) * time.Second
+		}
+		apiURL := config.Binance.ApiURL
+		if apiURL == "" {
+			apiURL = config.Binance.Market.APIURL()
 		}
 		myBinance = &Binance{
-			apiURL: config.Binance.ApiURL,
+			apiURL:        apiURL,
+			market:        config.Binance.Market,
+			client:        &http.Client{Timeout: timeout},
+			limiter:       NewBinanceRateLimiter(config.Binance.RateLimit.Threshold),
+			retryAttempts: BinanceRetryAttempts,
+			retryMaxDelay: BinanceRetryMaxDelay,
+		}
+		if config.Binance.Retry.Attempts > 0 {
+			myBinance.retryAttempts = config.Binance.Retry.Attempts
+		}
+		if config.Binance.Retry.MaxDelay > 0 {
+			myBinance.retryMaxDelay = time.Duration(config.Binance.Retry.MaxDelay) * time.Second
 		}
 	})
 	return myBinance
@@ -170,53 +489,119 @@ func (b *Binance) getApiURL() *url.URL {
 	return u
 }
 
-func (b *Binance) rateLimitWait(res *http.Response) bool {
-	retryAfterHeader := res.Header["Retry-After"]
-	if len(retryAfterHeader) > 0 {
-		retryAfter, err := strconv.Atoi(retryAfterHeader[0])
-		if err != nil {
-			log.Fatalf("error: %v", err)
+// backoff returns a full-jitter exponential delay for the attempt, capped at
+// the configured max delay.
+func (b *Binance) backoff(attempt int) time.Duration {
+	d := BinanceRetryBaseDelay << uint(attempt)
+	if d <= 0 || d > b.retryMaxDelay {
+		d = b.retryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// get performs a weighted GET request, retrying rate limited, server and
+// network errors up to the configured number of attempts.
+func (b *Binance) get(ctx context.Context, u *url.URL, weight int) ([]byte, error) {
+	var err error
+	for attempt := 0; attempt < b.retryAttempts; attempt++ {
+		if attempt > 0 {
+			delay := b.backoff(attempt - 1)
+			var rateLimited *BinanceRateLimitedError
+			if errors.As(err, &rateLimited) && rateLimited.RetryAfter > delay {
+				delay = rateLimited.RetryAfter
+			}
+			log.Printf("Binance API request failed (%v), retry %d/%d in %s...\n", err, attempt, b.retryAttempts-1, delay.Round(time.Millisecond))
+			if sleepErr := sleepContext(ctx, delay); sleepErr != nil {
+				return nil, sleepErr
+			}
+		}
+		var body []byte
+		body, err = b.getOnce(ctx, u, weight)
+		if err == nil {
+			return body, nil
+		}
+		if !isRetryable(err) {
+			return nil, err
 		}
-		log.Printf("Binance API rate limit reached, pause for %d seconds...\n", retryAfter)
-		time.Sleep(time.Duration(retryAfter) * time.Second)
-		log.Println("Resume")
-		return true
 	}
-	return false
+	return nil, err
 }
 
-func (b *Binance) ExchangeInfo() (*BinanceExchangeInfo, error) {
-	u := b.getApiURL()
-	u.Path = path.Join(u.Path, ExchangeInfoPath)
-	res, err := http.Get(u.String())
+func (b *Binance) getOnce(ctx context.Context, u *url.URL, weight int) ([]byte, error) {
+	if err := b.limiter.Wait(ctx, weight); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
+	res, err := b.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &BinanceNetworkError{Err: err}
+	}
+	b.limiter.Update(res.Header)
 	body, err := io.ReadAll(res.Body)
 	res.Body.Close()
+	if err != nil {
+		return nil, &BinanceNetworkError{Err: err}
+	}
+	if res.StatusCode > 299 {
+		return nil, newBinanceResponseError(res, body)
+	}
+	return body, nil
+}
+
+// ExchangeInfo returns the exchangeInfo of binance.market, futures symbols
+// hold their contract.
+func (b *Binance) ExchangeInfo(ctx context.Context) (*BinanceExchangeInfo, error) {
+	endpoints := binanceMarketEndpoints[b.market]
+	u := b.getApiURL()
+	u.Path = path.Join(u.Path, endpoints.exchangeInfoPath)
+	body, err := b.get(ctx, u, endpoints.exchangeInfoWeight)
 	if err != nil {
 		return nil, err
 	}
-	if b.rateLimitWait(res) {
-		return b.ExchangeInfo()
+	var data *BinanceExchangeInfo
+	if b.market.IsFutures() {
+		data, err = parseFuturesExchangeInfo(body)
+	} else {
+		data = &BinanceExchangeInfo{}
+		err = json.Unmarshal(body, data)
 	}
-	if res.StatusCode > 299 {
-		return nil, fmt.Errorf("%d:%s", res.StatusCode, body)
+	if err != nil {
+		return nil, err
 	}
-	var data BinanceExchangeInfo
+	b.limiter.SetLimits(data.RateLimits)
+	return data, nil
+}
+
+// Ticker24hr returns the 24h statistics of every symbol.
+func (b *Binance) Ticker24hr(ctx context.Context) ([]BinanceTicker24hr, error) {
+	endpoints := binanceMarketEndpoints[b.market]
+	u := b.getApiURL()
+	u.Path = path.Join(u.Path, endpoints.ticker24hrPath)
+	body, err := b.get(ctx, u, endpoints.ticker24hrWeight)
+	if err != nil {
+		return nil, err
+	}
+	data := []BinanceTicker24hr{}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
 		return nil, err
 	}
-	return &data, nil
+	return data, nil
 }
 
-func (b *Binance) Klines(symbol string, interval BinanceKlineInterval, opts ...*BinanceKlinesOptions) ([]BinanceKline, error) {
+func (b *Binance) Klines(ctx context.Context, symbol string, interval BinanceKlineInterval, opts ...*BinanceKlinesOptions) ([]BinanceKline, error) {
 	u := b.getApiURL()
-	u.Path = path.Join(u.Path, KlinesPath)
+	u.Path = path.Join(u.Path, binanceMarketEndpoints[b.market].klinesPath)
 	q := u.Query()
 	q.Set("symbol", symbol)
 	q.Set("interval", string(interval))
+	limit := 0
 	if len(opts) > 0 {
 		opt := opts[0]
 		if opt.StartTime != nil {
@@ -226,31 +611,22 @@ func (b *Binance) Klines(symbol string, interval BinanceKlineInterval, opts ...*
 			q.Set("endTime", strconv.FormatInt(*opt.EndTime, 10))
 		}
 		if opt.Limit != nil {
+			limit = *opt.Limit
 			q.Set("limit", strconv.FormatInt(int64(*opt.Limit), 10))
 		}
 	}
 	u.RawQuery = q.Encode()
-	res, err := http.Get(u.String())
+	body, err := b.get(ctx, u, KlinesWeight(limit))
 	if err != nil {
 		return nil, err
 	}
-	body, err := io.ReadAll(res.Body)
-	res.Body.Close()
-	if err != nil {
-		return nil, err
-	}
-	if b.rateLimitWait(res) {
-		return b.Klines(symbol, interval, opts...)
-	}
-	if res.StatusCode > 299 {
-		return nil, fmt.Errorf("%d:%s", res.StatusCode, body)
-	}
 	data := []BinanceKline{}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
 		return nil, err
 	}
 	for i := range data {
+		data[i].Market = string(b.market)
 		data[i].Symbol = symbol
 		data[i].Interval = string(interval)
 	}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// BinanceRateLimitedError is returned on HTTP 429, Binance asks us to back
+// off for RetryAfter.
+type BinanceRateLimitedError struct {
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *BinanceRateLimitedError) Error() string {
+	return fmt.Sprintf("binance: rate limited, retry after %s: %s", e.RetryAfter, e.Body)
+}
+
+// BinanceIPBannedError is returned on HTTP 418, the IP is banned for
+// RetryAfter after repeatedly ignoring 429s.
+type BinanceIPBannedError struct {
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *BinanceIPBannedError) Error() string {
+	return fmt.Sprintf("binance: IP banned, retry after %s: %s", e.RetryAfter, e.Body)
+}
+
+// BinanceClientError is returned on other 4xx responses, Code and Msg carry
+// the Binance error code, e.g. -1121 "Invalid symbol.".
+type BinanceClientError struct {
+	StatusCode int    `json:"-"`
+	Code       int    `json:"code"`
+	Msg        string `json:"msg"`
+}
+
+func (e *BinanceClientError) Error() string {
+	return fmt.Sprintf("binance: %d: code=%d, msg=%s", e.StatusCode, e.Code, e.Msg)
+}
+
+// BinanceServerError is returned on 5xx responses.
+type BinanceServerError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *BinanceServerError) Error() string {
+	return fmt.Sprintf("binance: %d: %s", e.StatusCode, e.Body)
+}
+
+// BinanceNetworkError wraps transport failures such as refused connections,
+// resets and timeouts.
+type BinanceNetworkError struct {
+	Err error
+}
+
+func (e *BinanceNetworkError) Error() string {
+	return fmt.Sprintf("binance: network error: %v", e.Err)
+}
+
+func (e *BinanceNetworkError) Unwrap() error {
+	return e.Err
+}
+
+// isRetryable reports whether the request may succeed when repeated. IP bans
+// are not retried to avoid escalating the ban on a shared IP.
+func isRetryable(err error) bool {
+	switch err.(type) {
+	case *BinanceRateLimitedError, *BinanceServerError, *BinanceNetworkError:
+		return true
+	}
+	return false
+}
+
+func parseRetryAfter(res *http.Response) time.Duration {
+	retryAfter, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil {
+		return 0
+	}
+	return time.Duration(retryAfter) * time.Second
+}
+
+func newBinanceResponseError(res *http.Response, body []byte) error {
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests:
+		return &BinanceRateLimitedError{RetryAfter: parseRetryAfter(res), Body: string(body)}
+	case res.StatusCode == http.StatusTeapot:
+		return &BinanceIPBannedError{RetryAfter: parseRetryAfter(res), Body: string(body)}
+	case res.StatusCode >= 500:
+		return &BinanceServerError{StatusCode: res.StatusCode, Body: string(body)}
+	default:
+		e := &BinanceClientError{StatusCode: res.StatusCode}
+		if err := json.Unmarshal(body, e); err != nil || e.Msg == "" {
+			e.Msg = string(body)
+		}
+		return e
+	}
+}
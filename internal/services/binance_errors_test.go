@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// statusServer answers the first len(statuses) requests with the given
+// statuses and later ones with 200, counting the requests in calls.
+func statusServer(calls *int32, header http.Header, statuses ...int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(calls, 1))
+		if n > len(statuses) {
+			w.Write([]byte("{}"))
+			return
+		}
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(statuses[n-1])
+		w.Write([]byte(`{"code":-1121,"msg":"Invalid symbol."}`))
+	}))
+}
+
+func TestBinanceGetRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    http.Header
+		statuses  []int
+		wantCalls int32
+		wantErr   error
+		minDelay  time.Duration
+	}{
+		{
+			name:      "rate limited honours Retry-After",
+			header:    http.Header{"Retry-After": {"1"}},
+			statuses:  []int{http.StatusTooManyRequests},
+			wantCalls: 2,
+			minDelay:  time.Second,
+		},
+		{
+			name:      "IP ban is not retried",
+			header:    http.Header{"Retry-After": {"120"}},
+			statuses:  []int{http.StatusTeapot},
+			wantCalls: 1,
+			wantErr:   &BinanceIPBannedError{},
+		},
+		{
+			name:      "server error is retried",
+			statuses:  []int{http.StatusBadGateway, http.StatusServiceUnavailable},
+			wantCalls: 3,
+		},
+		{
+			name:      "server error up to attempts",
+			statuses:  []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError},
+			wantCalls: 3,
+			wantErr:   &BinanceServerError{},
+		},
+		{
+			name:      "client error is not retried",
+			statuses:  []int{http.StatusBadRequest},
+			wantCalls: 1,
+			wantErr:   &BinanceClientError{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			server := statusServer(&calls, tt.header, tt.statuses...)
+			defer server.Close()
+			b := newTestBinance(server.URL, 3)
+
+			start := time.Now()
+			_, err := b.get(context.Background(), b.testURL("/api/v3/klines"), 1)
+			if calls := atomic.LoadInt32(&calls); calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minDelay {
+				t.Errorf("elapsed = %s, want at least %s", elapsed, tt.minDelay)
+			}
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("err = %v, want none", err)
+				}
+			case *BinanceIPBannedError:
+				if !errors.As(err, &want) || want.RetryAfter != 120*time.Second {
+					t.Errorf("err = %v, want an IP ban for 2m0s", err)
+				}
+			case *BinanceServerError:
+				if !errors.As(err, &want) || want.StatusCode != 500 {
+					t.Errorf("err = %v, want a 500 server error", err)
+				}
+			case *BinanceClientError:
+				if !errors.As(err, &want) || want.StatusCode != 400 || want.Code != -1121 || want.Msg != "Invalid symbol." {
+					t.Errorf("err = %v, want a 400 client error -1121", err)
+				}
+			}
+		})
+	}
+}
+
+func TestNewBinanceResponseError(t *testing.T) {
+	tests := []struct {
+		status    int
+		body      string
+		want      string
+		retryable bool
+	}{
+		{429, "slow down", "binance: rate limited, retry after 30s: slow down", true},
+		{418, "banned", "binance: IP banned, retry after 30s: banned", false},
+		{503, "unavailable", "binance: 503: unavailable", true},
+		{400, `{"code":-1100,"msg":"Illegal characters found."}`, "binance: 400: code=-1100, msg=Illegal characters found.", false},
+		{404, "<html>not found</html>", "binance: 404: code=0, msg=<html>not found</html>", false},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			res := &http.Response{StatusCode: tt.status, Header: http.Header{"Retry-After": {"30"}}}
+			err := newBinanceResponseError(res, []byte(tt.body))
+			if err.Error() != tt.want {
+				t.Errorf("err = %q, want %q", err, tt.want)
+			}
+			if isRetryable(err) != tt.retryable {
+				t.Errorf("retryable = %v, want %v", !tt.retryable, tt.retryable)
+			}
+		})
+	}
+	if !isRetryable(&BinanceNetworkError{Err: errors.New("connection reset")}) {
+		t.Error("network errors should be retryable")
+	}
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// BinanceMarket selects the Binance API symbols and klines are dumped from.
+type BinanceMarket string
+
+const (
+	// MarketSpot is the spot API.
+	MarketSpot BinanceMarket = "spot"
+	// MarketUSDM is the USD-M futures API, contracts margined in USDT or USDC.
+	MarketUSDM BinanceMarket = "usdm"
+	// MarketCoinM is the COIN-M futures API, contracts margined in the base
+	// asset.
+	MarketCoinM BinanceMarket = "coinm"
+)
+
+const (
+	USDMExchangeInfoPath  string = "/fapi/v1/exchangeInfo"
+	USDMKlinesPath        string = "/fapi/v1/klines"
+	USDMTicker24hrPath    string = "/fapi/v1/ticker/24hr"
+	CoinMExchangeInfoPath string = "/dapi/v1/exchangeInfo"
+	CoinMKlinesPath       string = "/dapi/v1/klines"
+	CoinMTicker24hrPath   string = "/dapi/v1/ticker/24hr"
+	// FuturesKlinesMaxLimit is the largest futures klines page.
+	FuturesKlinesMaxLimit int = 1500
+)
+
+// binanceEndpoints are the default API URL, paths and request weights of a
+// market.
+type binanceEndpoints struct {
+	apiURL             string
+	exchangeInfoPath   string
+	klinesPath         string
+	klinesMaxLimit     int
+	ticker24hrPath     string
+	exchangeInfoWeight int
+	ticker24hrWeight   int
+}
+
+var binanceMarketEndpoints = map[BinanceMarket]binanceEndpoints{
+	MarketSpot: {
+		apiURL:             "https://api.binance.com/",
+		exchangeInfoPath:   ExchangeInfoPath,
+		klinesPath:         KlinesPath,
+		klinesMaxLimit:     KlinesMaxLimit,
+		ticker24hrPath:     Ticker24hrPath,
+		exchangeInfoWeight: ExchangeInfoWeight,
+		ticker24hrWeight:   Ticker24hrWeight,
+	},
+	MarketUSDM: {
+		apiURL:             "https://fapi.binance.com/",
+		exchangeInfoPath:   USDMExchangeInfoPath,
+		klinesPath:         USDMKlinesPath,
+		klinesMaxLimit:     FuturesKlinesMaxLimit,
+		ticker24hrPath:     USDMTicker24hrPath,
+		exchangeInfoWeight: FuturesExchangeInfoWeight,
+		ticker24hrWeight:   FuturesTicker24hrWeight,
+	},
+	MarketCoinM: {
+		apiURL:             "https://dapi.binance.com/",
+		exchangeInfoPath:   CoinMExchangeInfoPath,
+		klinesPath:         CoinMKlinesPath,
+		klinesMaxLimit:     FuturesKlinesMaxLimit,
+		ticker24hrPath:     CoinMTicker24hrPath,
+		exchangeInfoWeight: FuturesExchangeInfoWeight,
+		ticker24hrWeight:   FuturesTicker24hrWeight,
+	},
+}
+
+func (m BinanceMarket) IsValid() bool {
+	_, ok := binanceMarketEndpoints[m]
+	return ok
+}
+
+// IsFutures reports whether m is a futures market.
+func (m BinanceMarket) IsFutures() bool {
+	return m == MarketUSDM || m == MarketCoinM
+}
+
+// APIURL returns the default API URL of the market.
+func (m BinanceMarket) APIURL() string {
+	return binanceMarketEndpoints[m].apiURL
+}
+
+// KlinesMaxLimit returns the largest klines page of the market.
+func (m BinanceMarket) KlinesMaxLimit() int {
+	return binanceMarketEndpoints[m].klinesMaxLimit
+}
+
+const (
+	ContractPerpetual      string = "PERPETUAL"
+	ContractCurrentMonth   string = "CURRENT_MONTH"
+	ContractNextMonth      string = "NEXT_MONTH"
+	ContractCurrentQuarter string = "CURRENT_QUARTER"
+	ContractNextQuarter    string = "NEXT_QUARTER"
+)
+
+// BinanceContract is the futures contract of a USD-M or COIN-M symbol.
+// Perpetual contracts have a delivery date far in the future.
+type BinanceContract struct {
+	Pair              string    `json:"pair" bson:"pair"`
+	ContractType      string    `json:"contractType" bson:"contractType"`
+	DeliveryDate      time.Time `json:"deliveryDate" bson:"deliveryDate"`
+	OnboardDate       time.Time `json:"onboardDate" bson:"onboardDate"`
+	MarginAsset       string    `json:"marginAsset" bson:"marginAsset"`
+	PricePrecision    int8      `json:"pricePrecision" bson:"pricePrecision"`
+	QuantityPrecision int8      `json:"quantityPrecision" bson:"quantityPrecision"`
+	// COIN-M only, the value of a contract in the quote asset
+	ContractSize int64 `json:"contractSize,omitempty" bson:"contractSize,omitempty"`
+	// USD-M only, e.g. COIN or INDEX
+	UnderlyingType string `json:"underlyingType,omitempty" bson:"underlyingType,omitempty"`
+}
+
+// IsPerpetual reports whether the contract has no delivery.
+func (c *BinanceContract) IsPerpetual() bool {
+	return c.ContractType == ContractPerpetual
+}
+
+// binanceFuturesSymbol is a symbol of the USD-M and COIN-M exchangeInfo.
+// USD-M reports status, COIN-M reports contractStatus.
+type binanceFuturesSymbol struct {
+	Symbol             string                `json:"symbol"`
+	Pair               string                `json:"pair"`
+	ContractType       string                `json:"contractType"`
+	DeliveryDate       int64                 `json:"deliveryDate"`
+	OnboardDate        int64                 `json:"onboardDate"`
+	Status             string                `json:"status"`
+	ContractStatus     string                `json:"contractStatus"`
+	ContractSize       int64                 `json:"contractSize"`
+	MarginAsset        string                `json:"marginAsset"`
+	BaseAsset          string                `json:"baseAsset"`
+	QuoteAsset         string                `json:"quoteAsset"`
+	PricePrecision     int8                  `json:"pricePrecision"`
+	QuantityPrecision  int8                  `json:"quantityPrecision"`
+	BaseAssetPrecision int8                  `json:"baseAssetPrecision"`
+	QuotePrecision     int8                  `json:"quotePrecision"`
+	UnderlyingType     string                `json:"underlyingType"`
+	OrderTypes         []string              `json:"orderTypes"`
+	Filters            []BinanceSymbolFilter `json:"filters"`
+}
+
+type binanceFuturesExchangeInfo struct {
+	Timezone        string                 `json:"timezone"`
+	ServerTime      int64                  `json:"serverTime"`
+	RateLimits      []BinanceRateLimit     `json:"rateLimits"`
+	ExchangeFilters []interface{}          `json:"exchangeFilters"`
+	Symbols         []binanceFuturesSymbol `json:"symbols"`
+}
+
+// symbol maps the futures symbol to a BinanceSymbol holding its contract.
+func (s *binanceFuturesSymbol) symbol() BinanceSymbol {
+	status := s.Status
+	if status == "" {
+		status = s.ContractStatus
+	}
+	filters := append([]BinanceSymbolFilter{}, s.Filters...)
+	for i := range filters {
+		// USD-M names the MIN_NOTIONAL value notional
+		if filters[i].MinNotional == "" && filters[i].Notional != "" {
+			filters[i].MinNotional = filters[i].Notional
+			filters[i].Notional = ""
+		}
+	}
+	return BinanceSymbol{
+		Symbol:             s.Symbol,
+		Status:             status,
+		BaseAsset:          s.BaseAsset,
+		BaseAssetPrecision: s.BaseAssetPrecision,
+		QuoteAsset:         s.QuoteAsset,
+		QuotePrecision:     s.QuotePrecision,
+		OrderTypes:         s.OrderTypes,
+		Filters:            filters,
+		Contract: &BinanceContract{
+			Pair:              s.Pair,
+			ContractType:      s.ContractType,
+			DeliveryDate:      time.UnixMilli(s.DeliveryDate).UTC(),
+			OnboardDate:       time.UnixMilli(s.OnboardDate).UTC(),
+			MarginAsset:       s.MarginAsset,
+			PricePrecision:    s.PricePrecision,
+			QuantityPrecision: s.QuantityPrecision,
+			ContractSize:      s.ContractSize,
+			UnderlyingType:    s.UnderlyingType,
+		},
+	}
+}
+
+// parseFuturesExchangeInfo parses the exchangeInfo of the USD-M and COIN-M
+// futures APIs.
+func parseFuturesExchangeInfo(body []byte) (*BinanceExchangeInfo, error) {
+	var data binanceFuturesExchangeInfo
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	info := &BinanceExchangeInfo{
+		Timezone:        data.Timezone,
+		ServerTime:      data.ServerTime,
+		RateLimits:      data.RateLimits,
+		ExchangeFilters: data.ExchangeFilters,
+	}
+	for i := range data.Symbols {
+		info.Symbols = append(info.Symbols, data.Symbols[i].symbol())
+	}
+	return info, nil
+}
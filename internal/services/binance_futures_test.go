@@ -0,0 +1,232 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// usdmExchangeInfo is an excerpt of /fapi/v1/exchangeInfo.
+const usdmExchangeInfo = `{
+  "timezone": "UTC",
+  "serverTime": 1729152000000,
+  "futuresType": "U_MARGINED",
+  "rateLimits": [
+    {"rateLimitType": "REQUEST_WEIGHT", "interval": "MINUTE", "intervalNum": 1, "limit": 2400},
+    {"rateLimitType": "ORDERS", "interval": "MINUTE", "intervalNum": 1, "limit": 1200},
+    {"rateLimitType": "ORDERS", "interval": "SECOND", "intervalNum": 10, "limit": 300}
+  ],
+  "exchangeFilters": [],
+  "assets": [{"asset": "USDT", "marginAvailable": true, "autoAssetExchange": "-10000"}],
+  "symbols": [
+    {
+      "symbol": "BTCUSDT", "pair": "BTCUSDT", "contractType": "PERPETUAL",
+      "deliveryDate": 4133404800000, "onboardDate": 1569398400000, "status": "TRADING",
+      "maintMarginPercent": "2.5000", "requiredMarginPercent": "5.0000",
+      "baseAsset": "BTC", "quoteAsset": "USDT", "marginAsset": "USDT",
+      "pricePrecision": 2, "quantityPrecision": 3, "baseAssetPrecision": 8, "quotePrecision": 8,
+      "underlyingType": "COIN", "underlyingSubType": ["PoW"], "settlePlan": 0,
+      "triggerProtect": "0.0500", "liquidationFee": "0.012500", "marketTakeBound": "0.05",
+      "maxMoveOrderLimit": 10000,
+      "filters": [
+        {"minPrice": "556.80", "maxPrice": "4529764", "filterType": "PRICE_FILTER", "tickSize": "0.10"},
+        {"stepSize": "0.001", "filterType": "LOT_SIZE", "maxQty": "1000", "minQty": "0.001"},
+        {"stepSize": "0.001", "filterType": "MARKET_LOT_SIZE", "maxQty": "120", "minQty": "0.001"},
+        {"limit": 200, "filterType": "MAX_NUM_ORDERS"},
+        {"limit": 10, "filterType": "MAX_NUM_ALGO_ORDERS"},
+        {"notional": "100", "filterType": "MIN_NOTIONAL"},
+        {"multiplierDown": "0.9500", "multiplierUp": "1.0500", "multiplierDecimal": "4", "filterType": "PERCENT_PRICE"}
+      ],
+      "orderTypes": ["LIMIT", "MARKET", "STOP", "STOP_MARKET", "TAKE_PROFIT", "TAKE_PROFIT_MARKET", "TRAILING_STOP_MARKET"],
+      "timeInForce": ["GTC", "IOC", "FOK", "GTX", "GTD"],
+      "permissionSets": ["GRID", "COPY"]
+    },
+    {
+      "symbol": "BTCUSDT_241227", "pair": "BTCUSDT", "contractType": "CURRENT_QUARTER",
+      "deliveryDate": 1735286400000, "onboardDate": 1719561600000, "status": "TRADING",
+      "maintMarginPercent": "2.5000", "requiredMarginPercent": "5.0000",
+      "baseAsset": "BTC", "quoteAsset": "USDT", "marginAsset": "USDT",
+      "pricePrecision": 1, "quantityPrecision": 3, "baseAssetPrecision": 8, "quotePrecision": 8,
+      "underlyingType": "COIN", "underlyingSubType": ["PoW"], "settlePlan": 0,
+      "triggerProtect": "0.0500", "liquidationFee": "0.010000", "marketTakeBound": "0.05",
+      "maxMoveOrderLimit": 10000,
+      "filters": [
+        {"minPrice": "576.3", "maxPrice": "1000000", "filterType": "PRICE_FILTER", "tickSize": "0.1"},
+        {"stepSize": "0.001", "filterType": "LOT_SIZE", "maxQty": "500", "minQty": "0.001"},
+        {"stepSize": "0.001", "filterType": "MARKET_LOT_SIZE", "maxQty": "5", "minQty": "0.001"},
+        {"limit": 200, "filterType": "MAX_NUM_ORDERS"},
+        {"limit": 10, "filterType": "MAX_NUM_ALGO_ORDERS"},
+        {"notional": "5", "filterType": "MIN_NOTIONAL"},
+        {"multiplierDown": "0.9500", "multiplierUp": "1.0500", "multiplierDecimal": "4", "filterType": "PERCENT_PRICE"}
+      ],
+      "orderTypes": ["LIMIT", "MARKET", "STOP", "STOP_MARKET", "TAKE_PROFIT", "TAKE_PROFIT_MARKET", "TRAILING_STOP_MARKET"],
+      "timeInForce": ["GTC", "IOC", "FOK", "GTX", "GTD"],
+      "permissionSets": ["GRID", "COPY"]
+    }
+  ]
+}`
+
+// coinmExchangeInfo is an excerpt of /dapi/v1/exchangeInfo.
+const coinmExchangeInfo = `{
+  "timezone": "UTC",
+  "serverTime": 1729152000000,
+  "rateLimits": [
+    {"rateLimitType": "REQUEST_WEIGHT", "interval": "MINUTE", "intervalNum": 1, "limit": 2400},
+    {"rateLimitType": "ORDERS", "interval": "MINUTE", "intervalNum": 1, "limit": 1200}
+  ],
+  "exchangeFilters": [],
+  "symbols": [
+    {
+      "filters": [
+        {"minPrice": "1000", "maxPrice": "4520958", "filterType": "PRICE_FILTER", "tickSize": "0.1"},
+        {"stepSize": "1", "filterType": "LOT_SIZE", "maxQty": "1000000", "minQty": "1"},
+        {"stepSize": "1", "filterType": "MARKET_LOT_SIZE", "maxQty": "60000", "minQty": "1"},
+        {"limit": 200, "filterType": "MAX_NUM_ORDERS"},
+        {"limit": 20, "filterType": "MAX_NUM_ALGO_ORDERS"},
+        {"multiplierDown": "0.9500", "multiplierUp": "1.0500", "multiplierDecimal": "4", "filterType": "PERCENT_PRICE"}
+      ],
+      "orderTypes": ["LIMIT", "MARKET", "STOP", "STOP_MARKET", "TAKE_PROFIT", "TAKE_PROFIT_MARKET", "TRAILING_STOP_MARKET"],
+      "timeInForce": ["GTC", "IOC", "FOK", "GTX"],
+      "liquidationFee": "0.015000", "marketTakeBound": "0.05",
+      "symbol": "BTCUSD_PERP", "pair": "BTCUSD", "contractType": "PERPETUAL",
+      "deliveryDate": 4133404800000, "onboardDate": 1597042800000, "contractStatus": "TRADING",
+      "contractSize": 100, "quoteAsset": "USD", "baseAsset": "BTC", "marginAsset": "BTC",
+      "pricePrecision": 1, "quantityPrecision": 0, "baseAssetPrecision": 8, "quotePrecision": 8,
+      "equalQtyPrecision": 4, "triggerProtect": "0.0500",
+      "maintMarginPercent": "2.5000", "requiredMarginPercent": "5.0000",
+      "underlyingType": "COIN", "underlyingSubType": []
+    },
+    {
+      "filters": [
+        {"minPrice": "1000", "maxPrice": "4520958", "filterType": "PRICE_FILTER", "tickSize": "0.1"},
+        {"stepSize": "1", "filterType": "LOT_SIZE", "maxQty": "1000000", "minQty": "1"},
+        {"stepSize": "1", "filterType": "MARKET_LOT_SIZE", "maxQty": "60000", "minQty": "1"},
+        {"limit": 200, "filterType": "MAX_NUM_ORDERS"},
+        {"limit": 20, "filterType": "MAX_NUM_ALGO_ORDERS"},
+        {"multiplierDown": "0.9500", "multiplierUp": "1.0500", "multiplierDecimal": "4", "filterType": "PERCENT_PRICE"}
+      ],
+      "orderTypes": ["LIMIT", "MARKET", "STOP", "STOP_MARKET", "TAKE_PROFIT", "TAKE_PROFIT_MARKET", "TRAILING_STOP_MARKET"],
+      "timeInForce": ["GTC", "IOC", "FOK", "GTX"],
+      "liquidationFee": "0.010000", "marketTakeBound": "0.05",
+      "symbol": "BTCUSD_240927", "pair": "BTCUSD", "contractType": "",
+      "deliveryDate": 1727424000000, "onboardDate": 1711699200000, "contractStatus": "DELIVERING",
+      "contractSize": 100, "quoteAsset": "USD", "baseAsset": "BTC", "marginAsset": "BTC",
+      "pricePrecision": 1, "quantityPrecision": 0, "baseAssetPrecision": 8, "quotePrecision": 8,
+      "equalQtyPrecision": 4, "triggerProtect": "0.0500",
+      "maintMarginPercent": "2.5000", "requiredMarginPercent": "5.0000",
+      "underlyingType": "COIN", "underlyingSubType": []
+    }
+  ]
+}`
+
+func TestParseFuturesExchangeInfo(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		symbol       string
+		status       string
+		quote        string
+		contractType string
+		contractSize int64
+		delivery     time.Time
+		minNotional  string
+		algoOrders   int
+		symbols      int
+	}{
+		{
+			name:         "usdm perpetual",
+			body:         usdmExchangeInfo,
+			symbol:       "BTCUSDT",
+			status:       "TRADING",
+			quote:        "USDT",
+			contractType: ContractPerpetual,
+			delivery:     time.UnixMilli(4133404800000).UTC(),
+			minNotional:  "100",
+			algoOrders:   10,
+			symbols:      2,
+		},
+		{
+			name:         "usdm quarterly",
+			body:         usdmExchangeInfo,
+			symbol:       "BTCUSDT_241227",
+			status:       "TRADING",
+			quote:        "USDT",
+			contractType: ContractCurrentQuarter,
+			delivery:     time.Date(2024, 12, 27, 8, 0, 0, 0, time.UTC),
+			minNotional:  "5",
+			algoOrders:   10,
+			symbols:      2,
+		},
+		{
+			name:         "coinm perpetual",
+			body:         coinmExchangeInfo,
+			symbol:       "BTCUSD_PERP",
+			status:       "TRADING",
+			quote:        "USD",
+			contractType: ContractPerpetual,
+			contractSize: 100,
+			delivery:     time.UnixMilli(4133404800000).UTC(),
+			algoOrders:   20,
+			symbols:      2,
+		},
+		{
+			name:         "coinm delivering",
+			body:         coinmExchangeInfo,
+			symbol:       "BTCUSD_240927",
+			status:       "DELIVERING",
+			quote:        "USD",
+			contractSize: 100,
+			delivery:     time.Date(2024, 9, 27, 8, 0, 0, 0, time.UTC),
+			algoOrders:   20,
+			symbols:      2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := parseFuturesExchangeInfo([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(info.Symbols) != tt.symbols {
+				t.Fatalf("symbols = %d, want %d", len(info.Symbols), tt.symbols)
+			}
+			if len(info.RateLimits) == 0 || info.RateLimits[0].Limit != 2400 {
+				t.Errorf("rate limits = %+v", info.RateLimits)
+			}
+			var symbol *BinanceSymbol
+			for i := range info.Symbols {
+				if info.Symbols[i].Symbol == tt.symbol {
+					symbol = &info.Symbols[i]
+				}
+			}
+			if symbol == nil {
+				t.Fatalf("symbol %s not parsed", tt.symbol)
+			}
+			if symbol.Status != tt.status || symbol.QuoteAsset != tt.quote {
+				t.Errorf("status = %q, quote = %q", symbol.Status, symbol.QuoteAsset)
+			}
+			contract := symbol.Contract
+			if contract == nil {
+				t.Fatal("contract not parsed")
+			}
+			if contract.ContractType != tt.contractType || contract.ContractSize != tt.contractSize {
+				t.Errorf("contract type = %q, size = %d", contract.ContractType, contract.ContractSize)
+			}
+			if !contract.DeliveryDate.Equal(tt.delivery) {
+				t.Errorf("delivery date = %v, want %v", contract.DeliveryDate, tt.delivery)
+			}
+			if f := symbol.Filter("MAX_NUM_ORDERS"); f == nil || f.Limit != 200 {
+				t.Errorf("MAX_NUM_ORDERS filter = %+v", f)
+			}
+			if f := symbol.Filter("MAX_NUM_ALGO_ORDERS"); f == nil || f.Limit != tt.algoOrders {
+				t.Errorf("MAX_NUM_ALGO_ORDERS filter = %+v", f)
+			}
+			f := symbol.Filter(FilterMinNotional)
+			switch {
+			case tt.minNotional == "" && f != nil:
+				t.Errorf("MIN_NOTIONAL filter = %+v, want none", f)
+			case tt.minNotional != "" && (f == nil || f.MinNotional != tt.minNotional || f.Notional != ""):
+				t.Errorf("MIN_NOTIONAL filter = %+v, want minNotional %s", f, tt.minNotional)
+			}
+		})
+	}
+}
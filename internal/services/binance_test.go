@@ -0,0 +1,182 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var binanceKlineColumnNames = []string{
+	"openTime", "open", "high", "low", "close", "volume",
+	"closeTime", "quoteVolume", "trades", "takerBuyVolume", "takerBuyQuoteVolume", "ignore",
+}
+
+// klineRecord returns a valid kline record with the columns named in fields,
+// given as name value pairs, overridden.
+func klineRecord(fields ...string) []string {
+	record := []string{
+		"1704067200000", "42283.58", "42554.57", "42261.02", "42475.23", "1271.68108",
+		"1704070799999", "53957248.973789", "47134", "682.57581", "28957416.819645", "0",
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		for col, name := range binanceKlineColumnNames {
+			if name == fields[i] {
+				record[col] = fields[i+1]
+			}
+		}
+	}
+	return record
+}
+
+func TestParseBinanceKlineRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  []string
+		wantErr string
+		check   func(t *testing.T, k BinanceKline)
+	}{
+		{
+			name:   "milliseconds",
+			record: klineRecord(),
+			check: func(t *testing.T, k BinanceKline) {
+				if got := k.OpenTime.UnixMilli(); got != 1704067200000 {
+					t.Errorf("openTime = %d", got)
+				}
+				if got := k.CloseTime.UnixMilli(); got != 1704070799999 {
+					t.Errorf("closeTime = %d", got)
+				}
+				if k.Open != 42283.58 || k.NumberOfTrades != 47134 {
+					t.Errorf("open = %v, trades = %d", k.Open, k.NumberOfTrades)
+				}
+			},
+		},
+		{
+			name:   "microseconds",
+			record: klineRecord("openTime", "1735689600000000", "closeTime", "1735693199999999"),
+			check: func(t *testing.T, k BinanceKline) {
+				if !k.OpenTime.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+					t.Errorf("openTime = %v", k.OpenTime.UTC())
+				}
+				if got := k.CloseTime.UnixMilli(); got != 1735693199999 {
+					t.Errorf("closeTime = %d", got)
+				}
+			},
+		},
+		{
+			name:   "smallest price",
+			record: klineRecord("low", "0.00000001"),
+			check: func(t *testing.T, k BinanceKline) {
+				if k.Low != 1e-8 || k.Decimals.Low != "0.00000001" {
+					t.Errorf("low = %v, decimal %q", k.Low, k.Decimals.Low)
+				}
+			},
+		},
+		{
+			name:   "large quote volume",
+			record: klineRecord("quoteVolume", "123456789012345678901.12345678"),
+			check: func(t *testing.T, k BinanceKline) {
+				if k.Decimals.QuoteAssetVolume != "123456789012345678901.12345678" {
+					t.Errorf("quote volume decimal = %q", k.Decimals.QuoteAssetVolume)
+				}
+				if got := k.DecimalStrings().QuoteAssetVolume; got != "123456789012345678901.12345678" {
+					t.Errorf("quote volume decimal string = %q", got)
+				}
+			},
+		},
+		{name: "missing column", record: klineRecord()[:11], wantErr: "expected 12 columns, got 11"},
+		{name: "malformed price", record: klineRecord("close", "42475.23x"), wantErr: "column 4"},
+		{name: "empty volume", record: klineRecord("volume", ""), wantErr: "column 5"},
+		{name: "fractional trades", record: klineRecord("trades", "47134.5"), wantErr: "column 8"},
+		{name: "malformed open time", record: klineRecord("openTime", "2024-01-01"), wantErr: "column 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParseBinanceKlineRecord(tt.record)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, k)
+		})
+	}
+}
+
+func TestBinanceKlineUnmarshalJSON(t *testing.T) {
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+		check   func(t *testing.T, k BinanceKline)
+	}{
+		{
+			name: "api array",
+			body: `[1704067200000,"42283.58","42554.57","42261.02","42475.23","1271.68108",1704070799999,"53957248.973789",47134,"682.57581","28957416.819645","0"]`,
+			check: func(t *testing.T, k BinanceKline) {
+				if got := k.OpenTime.UnixMilli(); got != 1704067200000 {
+					t.Errorf("openTime = %d", got)
+				}
+				if k.NumberOfTrades != 47134 || k.Decimals.Close != "42475.23" {
+					t.Errorf("trades = %d, close = %q", k.NumberOfTrades, k.Decimals.Close)
+				}
+			},
+		},
+		{
+			name: "microseconds",
+			body: `[1735689600000000,"1","1","1","1","1",1735693199999999,"1",1,"1","1","0"]`,
+			check: func(t *testing.T, k BinanceKline) {
+				if got := k.OpenTime.UnixMilli(); got != 1735689600000 {
+					t.Errorf("openTime = %d", got)
+				}
+			},
+		},
+		{
+			name:    "numeric price",
+			body:    `[1704067200000,42283.58,"42554.57","42261.02","42475.23","1271.68108",1704070799999,"53957248.973789",47134,"682.57581","28957416.819645","0"]`,
+			wantErr: "column 1: expected a string, got number 42283.58",
+		},
+		{
+			name:    "fractional open time",
+			body:    `[1704067200000.5,"1","1","1","1","1",1704070799999,"1",1,"1","1","0"]`,
+			wantErr: "column 0",
+		},
+		{
+			name:    "exponent trades",
+			body:    `[1704067200000,"1","1","1","1","1",1704070799999,"1",4.7e4,"1","1","0"]`,
+			wantErr: "column 8",
+		},
+		{
+			name:    "null column",
+			body:    `[1704067200000,null,"1","1","1","1",1704070799999,"1",1,"1","1","0"]`,
+			wantErr: "column 1: unexpected value",
+		},
+		{
+			name:    "short array",
+			body:    `[1704067200000,"1","1","1","1","1",1704070799999,"1",1,"1","1"]`,
+			wantErr: "expected 12 columns, got 11",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var k BinanceKline
+			err := json.Unmarshal([]byte(tt.body), &k)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, k)
+		})
+	}
+}
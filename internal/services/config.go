@@ -1,39 +1,157 @@
 package services
 
 import (
+	"fmt"
 	"io/ioutil"
-	"log"
 	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 var configOnce sync.Once
 var myConfig *Config
+var configErr error
+
+// WriteMode selects how klines already stored are written again.
+type WriteMode string
+
+const (
+	// WriteModeInsert only inserts klines not stored yet.
+	WriteModeInsert WriteMode = "insert"
+	// WriteModeReplace overwrites stored klines.
+	WriteModeReplace WriteMode = "replace"
+	// WriteModeUpdate overwrites stored klines whose values differ.
+	WriteModeUpdate WriteMode = "update"
+)
+
+func (m WriteMode) IsValid() bool {
+	switch m {
+	case WriteModeInsert, WriteModeReplace, WriteModeUpdate:
+		return true
+	}
+	return false
+}
+
+// KlinesInterval is one entry of binance.klines.intervals. It unmarshals
+// from either a plain interval string ("1h") or a mapping with optional
+// per-interval limit and since overrides.
+type KlinesInterval struct {
+	Interval BinanceKlineInterval `yaml:"interval"`
+	Limit    int                  `yaml:"limit"`
+	Since    string               `yaml:"since"`
+}
+
+func (ki *KlinesInterval) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var interval string
+	if err := unmarshal(&interval); err == nil {
+		ki.Interval = BinanceKlineInterval(interval)
+		return nil
+	}
+	type plain KlinesInterval
+	return unmarshal((*plain)(ki))
+}
 
 type Config struct {
 	Binance struct {
-		ApiURL        string `yaml:"apiURL"`
-		FilterPattern string `yaml:"filterPattern"`
-		Klines        struct {
-			Interval string `yaml:"interval"`
-			Limit    int    `yaml:"limit"`
+		ApiURL        string        `yaml:"apiURL"`
+		Market        BinanceMarket `yaml:"market"`
+		FilterPattern string        `yaml:"filterPattern"`
+		Symbols       struct {
+			Include       []string `yaml:"include"`
+			Exclude       []string `yaml:"exclude"`
+			QuoteAssets   []string `yaml:"quoteAssets"`
+			BaseAssets    []string `yaml:"baseAssets"`
+			Status        []string `yaml:"status"`
+			Permissions   []string `yaml:"permissions"`
+			ContractTypes []string `yaml:"contractTypes"`
+			Allow         []string `yaml:"allow"`
+			Deny          []string `yaml:"deny"`
+			Ranking       struct {
+				Top            int      `yaml:"top"`
+				MinQuoteVolume float64  `yaml:"minQuoteVolume"`
+				MinTrades      int64    `yaml:"minTrades"`
+				Stablecoins    []string `yaml:"stablecoins"`
+			} `yaml:"ranking"`
+		} `yaml:"symbols"`
+		Klines struct {
+			Interval        string           `yaml:"interval"`
+			Intervals       []KlinesInterval `yaml:"intervals"`
+			Limit           int              `yaml:"limit"`
+			Backfill        bool             `yaml:"backfill"`
+			Since           string           `yaml:"since"`
+			Incremental     bool             `yaml:"incremental"`
+			Workers         int              `yaml:"workers"`
+			ContinueOnError bool             `yaml:"continueOnError"`
 		} `yaml:"klines"`
 		Progress struct {
 			Interval int64 `yaml:"interval"`
 		} `yaml:"progress"`
+		RateLimit struct {
+			Threshold float64 `yaml:"threshold"`
+		} `yaml:"rateLimit"`
+		Retry struct {
+			Attempts int   `yaml:"attempts"`
+			MaxDelay int64 `yaml:"maxDelay"`
+		} `yaml:"retry"`
+		HTTP struct {
+			Timeout int64 `yaml:"timeout"`
+		} `yaml:"http"`
 	} `yaml:"binance"`
+	Storage struct {
+		Type      string    `yaml:"type"`
+		Decimal   bool      `yaml:"decimal"`
+		WriteMode WriteMode `yaml:"writeMode"`
+	} `yaml:"storage"`
 	Mongo struct {
-		URL     string `yaml:"url"`
-		DB      string `yaml:"db"`
+		URL       string `yaml:"url"`
+		DB        string `yaml:"db"`
+		BulkWrite struct {
+			BatchSize int `yaml:"batchSize"`
+			// Ordered defaults to true when the key is not set.
+			Ordered *bool `yaml:"ordered"`
+		} `yaml:"bulkWrite"`
+		WriteConcern struct {
+			W        string `yaml:"w"`
+			J        bool   `yaml:"j"`
+			WTimeout int64  `yaml:"wtimeout"`
+		} `yaml:"writeConcern"`
 		Binance struct {
 			SymbolsCollection string `yaml:"symbolsCollection"`
 			SymbolsIndexName  string `yaml:"symbolsIndexName"`
+			HistoryCollection string `yaml:"historyCollection"`
+			SeenCollection    string `yaml:"seenCollection"`
 			KlinesCollection  string `yaml:"klinesCollection"`
 			KlinesIndexName   string `yaml:"klinesIndexName"`
+			Timeseries        bool   `yaml:"timeseries"`
 		} `yaml:"binance"`
 	} `yaml:"mongo"`
+	SQLite struct {
+		Path            string `yaml:"path"`
+		SymbolsTable    string `yaml:"symbolsTable"`
+		KlinesTable     string `yaml:"klinesTable"`
+		KlinesIndexName string `yaml:"klinesIndexName"`
+	} `yaml:"sqlite"`
+	Postgres struct {
+		URL             string `yaml:"url"`
+		SymbolsTable    string `yaml:"symbolsTable"`
+		KlinesTable     string `yaml:"klinesTable"`
+		KlinesIndexName string `yaml:"klinesIndexName"`
+		Timescale       bool   `yaml:"timescale"`
+	} `yaml:"postgres"`
+	Parquet struct {
+		Dir             string `yaml:"dir"`
+		RowsPerFile     int    `yaml:"rowsPerFile"`
+		MaxBufferedRows int    `yaml:"maxBufferedRows"`
+	} `yaml:"parquet"`
+	File struct {
+		Dir  string `yaml:"dir"`
+		Gzip bool   `yaml:"gzip"`
+	} `yaml:"file"`
 	Notification struct {
 		Enable        bool   `yaml:"enable"`
 		RedisAddr     string `yaml:"redisAddr"`
@@ -44,38 +162,189 @@ type Config struct {
 	} `yaml:"notification"`
 }
 
-func GetConfig() *Config {
+// LoadConfig reads and validates the config file given in args, later calls
+// to GetConfig return the loaded config.
+func LoadConfig() (*Config, error) {
 	configOnce.Do(func() {
 		args := GetArgs()
 		content, err := ioutil.ReadFile(args.Config)
-
 		if err != nil {
-			log.Fatalf("error: %v", err)
+			configErr = err
+			return
 		}
 
 		var config Config
 		err = yaml.Unmarshal(content, &config)
 		if err != nil {
-			log.Fatalf("error: %v", err)
+			configErr = err
+			return
+		}
+
+		if config.Storage.WriteMode == "" {
+			config.Storage.WriteMode = WriteModeInsert
+		}
+		if config.Binance.Market == "" {
+			config.Binance.Market = MarketSpot
+		}
+		if config.Mongo.BulkWrite.Ordered == nil {
+			ordered := true
+			config.Mongo.BulkWrite.Ordered = &ordered
+		}
+		if config.Binance.Klines.Limit == 0 {
+			config.Binance.Klines.Limit = config.Binance.Market.KlinesMaxLimit()
+		}
+
+		err = config.validate()
+		if err != nil {
+			configErr = err
+			return
 		}
+		config.separateMarket()
 
 		myConfig = &config
 	})
+	return myConfig, configErr
+}
+
+// GetConfig returns the config loaded by LoadConfig.
+func GetConfig() *Config {
 	return myConfig
 }
 
-func (c *Config) Redact() Config {
-	var copyOf = *c
-	u, _ := url.Parse(copyOf.Mongo.URL)
+func (c *Config) validate() error {
+	if _, err := url.Parse(c.Binance.ApiURL); err != nil {
+		return fmt.Errorf("config: invalid binance apiURL: %v", err)
+	}
+	if _, err := regexp.Compile(c.Binance.FilterPattern); err != nil {
+		return fmt.Errorf("config: invalid binance filterPattern: %v", err)
+	}
+	for _, pattern := range append(append([]string{}, c.Binance.Symbols.Include...), c.Binance.Symbols.Exclude...) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("config: invalid binance symbols pattern %q: %v", pattern, err)
+		}
+	}
+	ranking := c.Binance.Symbols.Ranking
+	if ranking.Top < 0 || ranking.MinQuoteVolume < 0 || ranking.MinTrades < 0 {
+		return fmt.Errorf("config: binance symbols ranking top, minQuoteVolume and minTrades cannot be negative")
+	}
+	if !c.Binance.Market.IsValid() {
+		return fmt.Errorf("config: invalid binance market %q", c.Binance.Market)
+	}
+	for market, endpoints := range binanceMarketEndpoints {
+		if market != c.Binance.Market && c.Binance.ApiURL == endpoints.apiURL {
+			return fmt.Errorf("config: binance apiURL %s is the %s API, leave it blank for the %s market", c.Binance.ApiURL, market, c.Binance.Market)
+		}
+	}
+	if !c.Storage.WriteMode.IsValid() {
+		return fmt.Errorf("config: invalid storage writeMode %q", c.Storage.WriteMode)
+	}
+	for _, ki := range c.KlinesIntervals() {
+		if !ki.Interval.IsValid() {
+			return fmt.Errorf("config: invalid klines interval %q", ki.Interval)
+		}
+		// a page shorter than limit ends a backfill, a limit over the
+		// maximum would stop it after the first page
+		if maxLimit := c.Binance.Market.KlinesMaxLimit(); ki.Limit < 1 || ki.Limit > maxLimit {
+			return fmt.Errorf("config: klines limit %d for interval %s must be between 1 and %d for the %s market", ki.Limit, ki.Interval, maxLimit, c.Binance.Market)
+		}
+		if ki.Since != "" {
+			if _, err := time.Parse("2006-01-02", ki.Since); err != nil {
+				return fmt.Errorf("config: invalid klines since %q for interval %s: %v", ki.Since, ki.Interval, err)
+			}
+		}
+	}
+	return nil
+}
+
+// separateMarket keeps the symbols of futures markets apart from the spot
+// ones, so that tracking the symbols of one market does not delist those of
+// another. The symbols collection and tables are suffixed with the market and
+// the parquet and file dirs get a market subdir. Klines share their
+// collection and tables, keyed by market.
+func (c *Config) separateMarket() {
+	if !c.Binance.Market.IsFutures() {
+		return
+	}
+	market := string(c.Binance.Market)
+	suffix := func(name string) string {
+		if name == "" || strings.HasSuffix(name, "_"+market) {
+			return name
+		}
+		return name + "_" + market
+	}
+	c.Mongo.Binance.SymbolsCollection = suffix(c.Mongo.Binance.SymbolsCollection)
+	c.Mongo.Binance.HistoryCollection = suffix(c.Mongo.Binance.HistoryCollection)
+	c.Mongo.Binance.SeenCollection = suffix(c.Mongo.Binance.SeenCollection)
+	c.SQLite.SymbolsTable = suffix(c.SQLite.SymbolsTable)
+	c.Postgres.SymbolsTable = suffix(c.Postgres.SymbolsTable)
+	if c.Parquet.Dir != "" && filepath.Base(c.Parquet.Dir) != market {
+		c.Parquet.Dir = filepath.Join(c.Parquet.Dir, market)
+	}
+	if c.File.Dir != "" && filepath.Base(c.File.Dir) != market {
+		c.File.Dir = filepath.Join(c.File.Dir, market)
+	}
+}
+
+// KlinesIntervals resolves binance.klines.intervals, falling back to the
+// single binance.klines.interval, with limit and since defaulting to the
+// binance.klines values.
+func (c *Config) KlinesIntervals() []KlinesInterval {
+	intervals := c.Binance.Klines.Intervals
+	if len(intervals) == 0 {
+		intervals = []KlinesInterval{{Interval: BinanceKlineInterval(c.Binance.Klines.Interval)}}
+	}
+	var out []KlinesInterval
+	for _, ki := range intervals {
+		if ki.Limit == 0 {
+			ki.Limit = c.Binance.Klines.Limit
+		}
+		if ki.Since == "" {
+			ki.Since = c.Binance.Klines.Since
+		}
+		out = append(out, ki)
+	}
+	return out
+}
+
+// RedactedValue replaces secrets in the logged config.
+const RedactedValue string = "REDACTED"
+
+// dsnPassword matches the password of a key=value connection string, quoted
+// or not, e.g. host=localhost password='s3 cret'.
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S*)`)
+
+// redactURL replaces the password of a connection URL, in its user info or
+// query, or of a key=value connection string with RedactedValue. A URL that
+// does not parse is redacted whole.
+func redactURL(raw string) string {
+	if raw == "" {
+		return raw
+	}
+	if !strings.Contains(raw, "://") {
+		return dsnPassword.ReplaceAllString(raw, "${1}"+RedactedValue)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return RedactedValue
+	}
 	if u.User != nil {
-		p, _ := u.User.Password()
-		if p != "" {
-			u.User = url.UserPassword(u.User.Username(), "REDACTED")
+		if p, _ := u.User.Password(); p != "" {
+			u.User = url.UserPassword(u.User.Username(), RedactedValue)
 		}
-		copyOf.Mongo.URL = u.String()
 	}
+	if q := u.Query(); q.Get("password") != "" {
+		q.Set("password", RedactedValue)
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+func (c *Config) Redact() Config {
+	var copyOf = *c
+	copyOf.Mongo.URL = redactURL(copyOf.Mongo.URL)
+	copyOf.Postgres.URL = redactURL(copyOf.Postgres.URL)
 	if copyOf.Notification.RedisPassword != "" {
-		copyOf.Notification.RedisPassword = "REDACTED"
+		copyOf.Notification.RedisPassword = RedactedValue
 	}
 	return copyOf
 }
@@ -2,14 +2,20 @@ package services
 
 import (
 	"context"
-	"log"
+	"errors"
+	"strconv"
 	"sync"
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
+const MongoBulkWriteBatchSize int = 1000
+
 var mongoOnce sync.Once
 var myMongo *Mongo
 
@@ -38,14 +44,45 @@ func (mg *Mongo) cursorToArray(ctx context.Context, cur *mongo.Cursor) ([]interf
 	return results, nil
 }
 
-func (mg *Mongo) Connect(ctx context.Context) {
+func (mg *Mongo) Connect(ctx context.Context) error {
 	config := GetConfig()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(config.Mongo.URL))
+	opts := options.Client().ApplyURI(config.Mongo.URL)
+	if wc := mongoWriteConcern(config); wc != nil {
+		opts.SetWriteConcern(wc)
+	}
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
-		log.Fatalf("error: %v", err)
+		return err
 	}
 	mg.client = client
 	mg.db = config.Mongo.DB
+	return nil
+}
+
+// mongoWriteConcern builds the write concern configured under
+// mongo.writeConcern, nil leaves the one of the connection string.
+func mongoWriteConcern(config *Config) *writeconcern.WriteConcern {
+	wc := config.Mongo.WriteConcern
+	var opts []writeconcern.Option
+	if wc.W != "" {
+		if n, err := strconv.Atoi(wc.W); err == nil {
+			opts = append(opts, writeconcern.W(n))
+		} else if wc.W == "majority" {
+			opts = append(opts, writeconcern.WMajority())
+		} else {
+			opts = append(opts, writeconcern.WTagSet(wc.W))
+		}
+	}
+	if wc.J {
+		opts = append(opts, writeconcern.J(true))
+	}
+	if wc.WTimeout > 0 {
+		opts = append(opts, writeconcern.WTimeout(time.Duration(wc.WTimeout)*time.Millisecond))
+	}
+	if len(opts) == 0 {
+		return nil
+	}
+	return writeconcern.New(opts...)
 }
 
 func (mg *Mongo) Disconnect(ctx context.Context) error {
@@ -64,6 +101,10 @@ func (mg *Mongo) Find(ctx context.Context, col string, filter interface{}, opts
 	return mg.cursorToArray(ctx, cur)
 }
 
+func (mg *Mongo) FindOne(ctx context.Context, col string, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	return mg.Database().Collection(col).FindOne(ctx, filter, opts...)
+}
+
 func (mg *Mongo) UpdateMany(ctx context.Context, col string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
 	return mg.Database().Collection(col).UpdateMany(ctx, filter, update, opts...)
 }
@@ -72,10 +113,92 @@ func (mg *Mongo) BulkWrite(ctx context.Context, col string, models []mongo.Write
 	return mg.Database().Collection(col).BulkWrite(ctx, models, opts...)
 }
 
+// BulkWriteBatches runs models as consecutive bulk writes of at most
+// batchSize models each. Results are summed and the write errors of every
+// batch are merged into a single mongo.BulkWriteException indexed into
+// models. Ordered writes stop at the first failed batch, unordered ones write
+// every batch.
+func (mg *Mongo) BulkWriteBatches(ctx context.Context, col string, models []mongo.WriteModel, batchSize int, ordered bool) (*mongo.BulkWriteResult, error) {
+	if batchSize <= 0 {
+		batchSize = MongoBulkWriteBatchSize
+	}
+	opts := options.BulkWrite().SetOrdered(ordered)
+	total := &mongo.BulkWriteResult{UpsertedIDs: map[int64]interface{}{}}
+	var exception *mongo.BulkWriteException
+	for start := 0; start < len(models); start += batchSize {
+		end := start + batchSize
+		if end > len(models) {
+			end = len(models)
+		}
+		result, err := mg.BulkWrite(ctx, col, models[start:end], opts)
+		if result != nil {
+			total.InsertedCount += result.InsertedCount
+			total.MatchedCount += result.MatchedCount
+			total.ModifiedCount += result.ModifiedCount
+			total.DeletedCount += result.DeletedCount
+			total.UpsertedCount += result.UpsertedCount
+			for index, id := range result.UpsertedIDs {
+				total.UpsertedIDs[index+int64(start)] = id
+			}
+		}
+		if err == nil {
+			continue
+		}
+		var bwe mongo.BulkWriteException
+		if !errors.As(err, &bwe) {
+			return total, err
+		}
+		if exception == nil {
+			exception = &mongo.BulkWriteException{}
+		}
+		for _, writeErr := range bwe.WriteErrors {
+			writeErr.Index += start
+			exception.WriteErrors = append(exception.WriteErrors, writeErr)
+		}
+		if exception.WriteConcernError == nil {
+			exception.WriteConcernError = bwe.WriteConcernError
+		}
+		exception.Labels = append(exception.Labels, bwe.Labels...)
+		if ordered {
+			break
+		}
+	}
+	if exception != nil {
+		return total, *exception
+	}
+	return total, nil
+}
+
+// CollectionType returns the type of col, e.g. "collection" or
+// "timeseries", or blank if it does not exist.
+func (mg *Mongo) CollectionType(ctx context.Context, col string) (string, error) {
+	specs, err := mg.Database().ListCollectionSpecifications(ctx, bson.M{"name": col})
+	if err != nil {
+		return "", err
+	}
+	if len(specs) == 0 {
+		return "", nil
+	}
+	return specs[0].Type, nil
+}
+
+func (mg *Mongo) CreateCollection(ctx context.Context, col string, opts ...*options.CreateCollectionOptions) error {
+	return mg.Database().CreateCollection(ctx, col, opts...)
+}
+
+func (mg *Mongo) Cursor(ctx context.Context, col string, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	return mg.Database().Collection(col).Find(ctx, filter, opts...)
+}
+
 func (mg *Mongo) CreateIndex(ctx context.Context, col string, model mongo.IndexModel, opts ...*options.CreateIndexesOptions) (string, error) {
 	return mg.Database().Collection(col).Indexes().CreateOne(ctx, model, opts...)
 }
 
+func (mg *Mongo) DropIndex(ctx context.Context, col string, name string, opts ...*options.DropIndexesOptions) error {
+	_, err := mg.Database().Collection(col).Indexes().DropOne(ctx, name, opts...)
+	return err
+}
+
 func (mg *Mongo) ListIndexes(ctx context.Context, col string, opts ...*options.ListIndexesOptions) ([]primitive.D, error) {
 	cur, err := mg.Database().Collection(col).Indexes().List(ctx, opts...)
 	if err != nil {
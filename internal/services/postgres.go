@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	_ "github.com/lib/pq"
+)
+
+var postgresOnce sync.Once
+var myPostgres *Postgres
+
+type Postgres struct {
+	db *sql.DB
+}
+
+func GetPostgres() *Postgres {
+	postgresOnce.Do(func() {
+		myPostgres = &Postgres{}
+	})
+	return myPostgres
+}
+
+func (pg *Postgres) Connect(ctx context.Context, url string) error {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return err
+	}
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	pg.db = db
+	return nil
+}
+
+func (pg *Postgres) Close() error {
+	return pg.db.Close()
+}
+
+func (pg *Postgres) DB() *sql.DB {
+	return pg.db
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RequestWeightLimitType string = "REQUEST_WEIGHT"
+	UsedWeightHeaderPrefix string = "X-Mbx-Used-Weight-"
+
+	ExchangeInfoWeight int = 20
+	// Ticker24hrWeight is the weight of /api/v3/ticker/24hr for all symbols
+	Ticker24hrWeight int = 80
+
+	// FuturesExchangeInfoWeight and FuturesTicker24hrWeight are the weights
+	// of the USD-M and COIN-M futures exchangeInfo and 24h ticker
+	FuturesExchangeInfoWeight int = 1
+	FuturesTicker24hrWeight   int = 40
+)
+
+// weightWindow tracks the request weight used within one Binance rate limit
+// window, e.g. 6000 weight per 1 minute.
+type weightWindow struct {
+	key      string
+	interval time.Duration
+	limit    int
+	used     int
+	start    time.Time
+}
+
+func (w *weightWindow) roll(now time.Time) {
+	start := now.Truncate(w.interval)
+	if !start.Equal(w.start) {
+		w.start = start
+		w.used = 0
+	}
+}
+
+// BinanceRateLimiter budgets request weight against the REQUEST_WEIGHT limits
+// announced by exchangeInfo and the X-MBX-USED-WEIGHT-* response headers, and
+// pauses before the ceiling is reached rather than after Binance throttles us.
+type BinanceRateLimiter struct {
+	mu        sync.Mutex
+	windows   []*weightWindow
+	threshold float64
+}
+
+// NewBinanceRateLimiter starts with a conservative 1200 weight per minute
+// until SetLimits is called with the limits from exchangeInfo.
+func NewBinanceRateLimiter(threshold float64) *BinanceRateLimiter {
+	if threshold <= 0 || threshold > 1 {
+		threshold = 0.9
+	}
+	return &BinanceRateLimiter{
+		windows:   []*weightWindow{{key: "1m", interval: time.Minute, limit: 1200}},
+		threshold: threshold,
+	}
+}
+
+func rateLimitIntervalDuration(interval string, num int32) (time.Duration, string) {
+	var unit time.Duration
+	var letter string
+	switch interval {
+	case "SECOND":
+		unit, letter = time.Second, "s"
+	case "MINUTE":
+		unit, letter = time.Minute, "m"
+	case "HOUR":
+		unit, letter = time.Hour, "h"
+	case "DAY":
+		unit, letter = 24*time.Hour, "d"
+	default:
+		return 0, ""
+	}
+	return time.Duration(num) * unit, strconv.Itoa(int(num)) + letter
+}
+
+// SetLimits replaces the tracked windows with the REQUEST_WEIGHT limits,
+// keeping the weight already used in windows that still apply.
+func (rl *BinanceRateLimiter) SetLimits(limits []BinanceRateLimit) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	var windows []*weightWindow
+	for _, l := range limits {
+		if l.RateLimitType != RequestWeightLimitType {
+			continue
+		}
+		interval, key := rateLimitIntervalDuration(l.Interval, l.IntervalNum)
+		if interval == 0 {
+			continue
+		}
+		w := &weightWindow{key: key, interval: interval, limit: int(l.Limit)}
+		for _, old := range rl.windows {
+			if old.key == key {
+				w.used, w.start = old.used, old.start
+			}
+		}
+		windows = append(windows, w)
+	}
+	if len(windows) > 0 {
+		rl.windows = windows
+	}
+}
+
+// Wait blocks until weight fits under the threshold of every window, then
+// reserves it. It returns early with the context error when ctx is done.
+func (rl *BinanceRateLimiter) Wait(ctx context.Context, weight int) error {
+	for {
+		rl.mu.Lock()
+		now := time.Now()
+		var wait time.Duration
+		for _, w := range rl.windows {
+			w.roll(now)
+			if float64(w.used+weight) > float64(w.limit)*rl.threshold {
+				if d := w.start.Add(w.interval).Sub(now); d > wait {
+					wait = d
+				}
+			}
+		}
+		if wait == 0 {
+			for _, w := range rl.windows {
+				w.used += weight
+			}
+			rl.mu.Unlock()
+			return nil
+		}
+		rl.mu.Unlock()
+		log.Printf("Binance API weight budget near limit, pause for %s...\n", wait.Round(time.Millisecond))
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// sleepContext pauses for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Update syncs the used weight with the X-MBX-USED-WEIGHT-* headers.
+func (rl *BinanceRateLimiter) Update(header http.Header) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	for name, values := range header {
+		if len(values) == 0 || !strings.HasPrefix(name, UsedWeightHeaderPrefix) {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(name, UsedWeightHeaderPrefix))
+		used, err := strconv.Atoi(values[0])
+		if err != nil {
+			continue
+		}
+		for _, w := range rl.windows {
+			if w.key != key {
+				continue
+			}
+			w.roll(now)
+			if used > w.used {
+				w.used = used
+			}
+		}
+	}
+}
+
+// KlinesWeight returns the request weight of /api/v3/klines for limit.
+func KlinesWeight(limit int) int {
+	switch {
+	case limit <= 0:
+		return 2
+	case limit <= 100:
+		return 1
+	case limit <= 500:
+		return 2
+	case limit <= 1000:
+		return 5
+	default:
+		return 10
+	}
+}
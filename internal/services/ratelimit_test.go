@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// newTestBinance returns a client for the httptest server at serverURL that
+// retries without delay unless the server asks for one.
+func newTestBinance(serverURL string, attempts int) *Binance {
+	return &Binance{
+		apiURL:        serverURL,
+		market:        MarketSpot,
+		client:        &http.Client{Timeout: 5 * time.Second},
+		limiter:       NewBinanceRateLimiter(0.9),
+		retryAttempts: attempts,
+		retryMaxDelay: time.Millisecond,
+	}
+}
+
+func (b *Binance) testURL(path string) *url.URL {
+	u := b.getApiURL()
+	u.Path = path
+	return u
+}
+
+func TestWeightWindowRoll(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := &weightWindow{key: "1m", interval: time.Minute, limit: 1200}
+	w.roll(start.Add(10 * time.Second))
+	if !w.start.Equal(start) {
+		t.Fatalf("start = %v, want %v", w.start, start)
+	}
+	w.used = 500
+	w.roll(start.Add(59 * time.Second))
+	if w.used != 500 {
+		t.Errorf("used within the window = %d, want 500", w.used)
+	}
+	w.roll(start.Add(time.Minute))
+	if w.used != 0 || !w.start.Equal(start.Add(time.Minute)) {
+		t.Errorf("used/start in the next window = %d/%v, want 0/%v", w.used, w.start, start.Add(time.Minute))
+	}
+}
+
+func TestBinanceRateLimiterSetLimits(t *testing.T) {
+	rl := NewBinanceRateLimiter(0.9)
+	if err := rl.Wait(context.Background(), 100); err != nil {
+		t.Fatal(err)
+	}
+	rl.SetLimits([]BinanceRateLimit{
+		{RateLimitType: RequestWeightLimitType, Interval: "MINUTE", IntervalNum: 1, Limit: 6000},
+		{RateLimitType: "ORDERS", Interval: "SECOND", IntervalNum: 10, Limit: 100},
+		{RateLimitType: RequestWeightLimitType, Interval: "HOUR", IntervalNum: 1, Limit: 100000},
+	})
+	if len(rl.windows) != 2 {
+		t.Fatalf("windows = %d, want 2", len(rl.windows))
+	}
+	if w := rl.windows[0]; w.key != "1m" || w.limit != 6000 || w.used != 100 {
+		t.Errorf("1m window = %s/%d/%d, want 1m/6000/100", w.key, w.limit, w.used)
+	}
+	if w := rl.windows[1]; w.key != "1h" || w.interval != time.Hour || w.used != 0 {
+		t.Errorf("1h window = %s/%s/%d, want 1h/1h0m0s/0", w.key, w.interval, w.used)
+	}
+}
+
+func TestBinanceRateLimiterUpdate(t *testing.T) {
+	var used string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-MBX-USED-WEIGHT-1M", used)
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	b := newTestBinance(server.URL, 1)
+	b.limiter.SetLimits([]BinanceRateLimit{{RateLimitType: RequestWeightLimitType, Interval: "MINUTE", IntervalNum: 1, Limit: 6000}})
+	u := b.testURL("/api/v3/ping")
+
+	for _, tt := range []struct {
+		header string
+		want   int
+	}{
+		// another process sharing the IP used weight we did not count
+		{"1100", 1100},
+		// a lagging header never lowers what was reserved since
+		{"10", 1101},
+		{"invalid", 1102},
+	} {
+		used = tt.header
+		if _, err := b.get(context.Background(), u, 1); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.limiter.windows[0].used; got != tt.want {
+			t.Errorf("header %s: used = %d, want %d", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestBinanceRateLimiterWait(t *testing.T) {
+	t.Run("blocks until the window rolls", func(t *testing.T) {
+		rl := NewBinanceRateLimiter(0.5)
+		rl.SetLimits([]BinanceRateLimit{{RateLimitType: RequestWeightLimitType, Interval: "SECOND", IntervalNum: 1, Limit: 10}})
+		if err := rl.Wait(context.Background(), 5); err != nil {
+			t.Fatal(err)
+		}
+		start := rl.windows[0].start
+		if err := rl.Wait(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+		if w := rl.windows[0]; !w.start.After(start) || w.used != 1 {
+			t.Errorf("start/used = %v/%d, want after %v/1", w.start, w.used, start)
+		}
+	})
+
+	t.Run("returns when the context is done", func(t *testing.T) {
+		rl := NewBinanceRateLimiter(0.5)
+		rl.SetLimits([]BinanceRateLimit{{RateLimitType: RequestWeightLimitType, Interval: "HOUR", IntervalNum: 1, Limit: 10}})
+		if err := rl.Wait(context.Background(), 4); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := rl.Wait(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+		}
+		if used := rl.windows[0].used; used != 4 {
+			t.Errorf("used = %d, want 4", used)
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var sqliteOnce sync.Once
+var mySQLite *SQLite
+
+type SQLite struct {
+	db *sql.DB
+}
+
+func GetSQLite() *SQLite {
+	sqliteOnce.Do(func() {
+		mySQLite = &SQLite{}
+	})
+	return mySQLite
+}
+
+func (sl *SQLite) Connect(ctx context.Context) error {
+	config := GetConfig()
+	db, err := sql.Open("sqlite3", "file:"+config.SQLite.Path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return err
+	}
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	sl.db = db
+	return nil
+}
+
+func (sl *SQLite) Close() error {
+	return sl.db.Close()
+}
+
+func (sl *SQLite) DB() *sql.DB {
+	return sl.db
+}
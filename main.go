@@ -2,20 +2,35 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/atton16/go-pair-dump/internal/app"
 	"github.com/atton16/go-pair-dump/internal/services"
 )
 
+// ShutdownTimeout bounds the writes and notifications still running once the
+// process is signalled.
+const ShutdownTimeout time.Duration = 30 * time.Second
+
 func main() {
+	code := run()
+	log.Printf("exit code: %d\n", code)
+	os.Exit(int(code))
+}
+
+// run dumps symbols and klines and returns the process exit code, so that
+// deferred cleanups run before main exits.
+func run() app.ExitCode {
 	start := time.Now()
 	var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
@@ -25,13 +40,105 @@ func main() {
 	log.Printf("args: %s\n", txt)
 
 	// Get config
-	var config = services.GetConfig()
+	config, err := services.LoadConfig()
+	if err != nil {
+		log.Printf("error: %v", err)
+		return app.ExitConfig
+	}
 	txt, _ = json.MarshalIndent(config.Redact(), "", "  ")
 	log.Printf("config: %s\n", txt)
 
-	// Main context
-	ctx, cancel := context.WithCancel(context.Background())
+	// Main context, cancelled on SIGINT/SIGTERM
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
+	// Writes and final notifications outlive the main context so that an
+	// in-flight bulk write and the cancelled notification still complete,
+	// within ShutdownTimeout. Signals are released on the first one, a
+	// second signal kills the process
+	storeCtx, storeCancel := context.WithCancel(context.Background())
+	defer storeCancel()
+	go func() {
+		<-ctx.Done()
+		cancel()
+		time.AfterFunc(ShutdownTimeout, storeCancel)
+	}()
+
+	// Print the selected symbols, nothing is stored or notified
+	if args.ListSymbols != nil {
+		exchangeSymbols, err := app.GetSymbols(ctx)
+		if err != nil {
+			log.Printf("error: %v", err)
+			return app.ExitCodeOf(err)
+		}
+		selected := app.FilterSymbols(exchangeSymbols)
+		if app.RankingEnabled(config) {
+			var ranked []app.RankedSymbol
+			selected, ranked, err = app.RankSymbols(ctx, selected)
+			if err != nil {
+				log.Printf("error: %v", err)
+				return app.ExitCodeOf(err)
+			}
+			for _, r := range ranked {
+				log.Printf("ranking: %s\n", r)
+			}
+		}
+		for _, symbol := range selected {
+			if args.ListSymbols.JSON {
+				line, _ := json.Marshal(symbol)
+				fmt.Println(string(line))
+				continue
+			}
+			if symbol.Contract != nil {
+				fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n", symbol.Symbol, symbol.Status, symbol.BaseAsset, symbol.QuoteAsset,
+					symbol.Contract.ContractType, symbol.Contract.DeliveryDate.Format("2006-01-02"))
+				continue
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", symbol.Symbol, symbol.Status, symbol.BaseAsset, symbol.QuoteAsset)
+		}
+		log.Printf("selected symbols: %d/%d\n", len(selected), len(exchangeSymbols))
+		return app.ExitOK
+	}
+
+	var jobs int
+	var jobsDone int64
+	klinesCount := int64(0)
+	matchedCount := int64(0)
+	upsertedCount := int64(0)
+	modifiedCount := int64(0)
+
+	// logKlines reports klines inserted, updated (stored and rewritten) and
+	// unchanged (stored and kept) so far
+	logKlines := func() {
+		matched := atomic.LoadInt64(&matchedCount)
+		modified := atomic.LoadInt64(&modifiedCount)
+		log.Printf("klines: inserted=%d, updated=%d, unchanged=%d\n", atomic.LoadInt64(&upsertedCount), modified, matched-modified)
+	}
+
+	// fail notifies err and maps it to an exit code, cancellations publish
+	// the progress made so far instead of an error
+	fail := func(err error) app.ExitCode {
+		code := app.ExitCodeOf(err)
+		if code == app.ExitCancelled {
+			log.Printf("shutdown: cancelled after %d/%d symbol intervals\n", jobsDone, jobs)
+			app.NotifyCancelled(storeCtx, app.PairdumpProgress{
+				Jobs:     jobs,
+				JobsDone: jobsDone,
+				Klines:   atomic.LoadInt64(&klinesCount),
+				Matched:  atomic.LoadInt64(&matchedCount),
+				Upserted: atomic.LoadInt64(&upsertedCount),
+				Modified: atomic.LoadInt64(&modifiedCount),
+			})
+			log.Printf("Process took %s", time.Since(start))
+			log.Println("Cancelled")
+			return code
+		}
+		app.NotifyError(storeCtx, app.ScopeOf(err), err)
+		log.Printf("error: %v", err)
+		for _, doc := range app.DocumentErrorsOf(err) {
+			log.Printf("error: document %s\n", doc)
+		}
+		return code
+	}
 
 	// Get redis
 	var rd = services.GetRedis()
@@ -44,89 +151,135 @@ func main() {
 		log.Println("notification: disabled")
 	}
 
-	// Mongo connect
-	var mongoSvc = services.GetMongo()
-	mongoSvc.Connect(ctx)
-	defer mongoSvc.Disconnect(ctx)
-
-	// Ensure index on symbol collection
-	indexModel := mongo.IndexModel{
-		Keys: bson.D{
-			primitive.E{Key: "symbol", Value: 1},
-		},
-		Options: options.Index().SetUnique(true).SetName(config.Mongo.Binance.SymbolsIndexName),
-	}
-	log.Printf("ensureIndex: ensuring index %s...\n", config.Mongo.Binance.SymbolsIndexName)
-	indexCreated, err := app.EnsureIndex(ctx, config.Mongo.Binance.SymbolsCollection, config.Mongo.Binance.SymbolsIndexName, indexModel)
+	// Storage connect
+	sink, err := app.NewSink(config)
 	if err != nil {
-		app.NotifyError(ctx, app.AppEnsureIndex, err)
-		log.Fatalf("error: %v", err)
+		return fail(app.NewScopeError(app.AppConfig, app.ExitConfig, err))
 	}
-	if indexCreated == nil {
-		log.Println("ensureIndex: index already exists, do nothing.")
-	} else {
-		log.Println("ensureIndex: index created!")
+	log.Printf("storage: %s\n", config.Storage.Type)
+	if _, ok := sink.(app.SymbolTracker); !ok {
+		log.Printf("warning: storage %s does not track the symbol lifecycle, status changes and delistings are not recorded\n", config.Storage.Type)
+	}
+	if err := sink.Connect(ctx); err != nil {
+		return fail(app.NewScopeError(app.AppConnect, app.ExitStorage, err))
 	}
+	defer sink.Close(storeCtx)
 
-	// Ensure index on klines collection
-	indexModel = mongo.IndexModel{
-		Keys: bson.D{
-			primitive.E{Key: "symbol", Value: 1},
-			primitive.E{Key: "interval", Value: 1},
-			primitive.E{Key: "openTime", Value: 1},
-		},
-		Options: options.Index().SetUnique(true).SetName(config.Mongo.Binance.KlinesIndexName),
+	// Ensure schema (indexes, tables) on storage
+	if err := sink.EnsureSchema(ctx); err != nil {
+		return fail(app.NewScopeError(app.AppEnsureSchema, app.ExitStorage, err))
 	}
-	log.Printf("ensureIndex: ensuring index %s...\n", config.Mongo.Binance.KlinesIndexName)
-	indexCreated, err = app.EnsureIndex(ctx, config.Mongo.Binance.KlinesCollection, config.Mongo.Binance.KlinesIndexName, indexModel)
-	if err != nil {
-		app.NotifyError(ctx, app.AppEnsureIndex, err)
-		log.Fatalf("error: %v", err)
+
+	dumpKlines := func(klines []services.BinanceKline) error {
+		atomic.AddInt64(&klinesCount, int64(len(klines)))
+		// log.Printf("klines: %+v\n", klines)
+		// log.Printf("total klines: %d\n", len(klines))
+		// BulkWrite
+		result, err := sink.UpsertKlines(storeCtx, klines)
+		// log.Printf("%+v", result)
+		if result != nil {
+			atomic.AddInt64(&matchedCount, result.MatchedCount)
+			atomic.AddInt64(&upsertedCount, result.UpsertedCount)
+			atomic.AddInt64(&modifiedCount, result.ModifiedCount)
+		}
+		if err != nil {
+			return app.NewScopeError(app.AppBulkWrite, app.ExitStorage, err)
+		}
+		return nil
 	}
-	if indexCreated == nil {
-		log.Println("ensureIndex: index already exists, do nothing.")
-	} else {
-		log.Println("ensureIndex: index created!")
+
+	// Import klines from Binance public data archives instead of the API
+	if args.Import != nil {
+		log.Printf("import: dir=%s, verifyChecksum=%v\n", args.Import.Dir, !args.Import.SkipChecksum)
+		jobs, jobsDone, err = app.ImportKlines(ctx, args.Import.Dir, !args.Import.SkipChecksum, dumpKlines)
+		if flusher, ok := sink.(app.Flusher); ok {
+			if flushErr := flusher.Flush(storeCtx); flushErr != nil && err == nil {
+				err = app.NewScopeError(app.AppBulkWrite, app.ExitStorage, flushErr)
+			}
+		}
+		logKlines()
+		log.Printf("total klines: %d, archives: %d/%d\n", klinesCount, jobsDone, jobs)
+		if err != nil {
+			return fail(err)
+		}
+		app.NotifyOK(storeCtx, app.StatusDone)
+		log.Printf("Process took %s", time.Since(start))
+		log.Println("Done")
+		return app.ExitOK
+	}
+
+	// Copy a regular klines collection into the time-series one
+	if args.Migrate != nil {
+		mongoSink, ok := sink.(*app.MongoSink)
+		if !ok || !config.Mongo.Binance.Timeseries {
+			return fail(app.NewScopeError(app.AppConfig, app.ExitConfig, errors.New("migrate: requires storage.type mongo and mongo.binance.timeseries")))
+		}
+		log.Printf("migrate: from=%s, to=%s\n", args.Migrate.From, config.Mongo.Binance.KlinesCollection)
+		read, err := app.MigrateTimeseries(ctx, mongoSink, args.Migrate.From, func(result *app.UpsertResult) {
+			atomic.AddInt64(&matchedCount, result.MatchedCount)
+			atomic.AddInt64(&upsertedCount, result.UpsertedCount)
+			logKlines()
+		})
+		klinesCount = read
+		log.Printf("total klines: %d\n", klinesCount)
+		if err != nil {
+			return fail(err)
+		}
+		app.NotifyOK(storeCtx, app.StatusDone)
+		log.Printf("Process took %s", time.Since(start))
+		log.Println("Done")
+		return app.ExitOK
 	}
 
 	// Get symbols
-	var symbols *[]string = app.GetSymbols(ctx)
+	exchangeSymbols, err := app.GetSymbols(ctx)
+	if err != nil {
+		return fail(err)
+	}
+	symbolInfos := app.FilterSymbols(exchangeSymbols)
+	// Narrow down to the most liquid symbols by 24h quote volume
+	if app.RankingEnabled(config) {
+		var ranked []app.RankedSymbol
+		symbolInfos, ranked, err = app.RankSymbols(ctx, symbolInfos)
+		if err != nil {
+			return fail(err)
+		}
+		for _, r := range ranked {
+			log.Printf("ranking: %s\n", r)
+		}
+		log.Printf("ranking: kept %d/%d symbols\n", len(symbolInfos), len(ranked))
+	}
+	symbols := app.SymbolNames(symbolInfos)
 	// log.Printf("symbols: %+v\n", symbols)
-	log.Printf("fetched symbols: %d\n", len(*symbols))
+	log.Printf("fetched symbols: %d\n", len(symbols))
 
-	log.Println("Start dumping symbols...")
-	var bulkWriteModels []mongo.WriteModel
-	for _, symbol := range *symbols {
-		updateOne := mongo.NewUpdateOneModel()
-		updateOne.SetFilter(bson.M{
-			"symbol": symbol,
-		})
-		now := time.Now()
-		updateOne.SetUpdate(bson.M{
-			"$setOnInsert": bson.M{
-				"symbol":    symbol,
-				"createdAt": now,
-				"updatedAt": now,
-			},
-		})
-		updateOne.SetUpsert(true)
-		bulkWriteModels = append(bulkWriteModels, updateOne)
+	// Record listings, status changes and delistings before the upsert
+	// refreshes the stored metadata. Every exchange symbol is tracked, a
+	// stored symbol no longer selected, e.g. gone from TRADING to BREAK,
+	// still gets its status change and a symbol entering the selection is
+	// only listed if exchangeInfo never returned it before
+	if tracker, ok := sink.(app.SymbolTracker); ok {
+		events, err := tracker.TrackSymbols(storeCtx, exchangeSymbols)
+		if err != nil {
+			return fail(app.NewScopeError(app.AppTrackSymbols, app.ExitStorage, err))
+		}
+		for _, event := range events {
+			log.Printf("symbols: %s\n", event)
+			app.NotifySymbolEvent(storeCtx, event)
+		}
+		log.Printf("symbols: lifecycle events=%d\n", len(events))
 	}
-	// BulkWrite symbols
-	result, err := mongoSvc.BulkWrite(ctx, config.Mongo.Binance.SymbolsCollection, bulkWriteModels)
+
+	log.Println("Start dumping symbols...")
+	result, err := sink.UpsertSymbols(storeCtx, symbolInfos)
 	if err != nil {
-		app.NotifyError(ctx, app.AppBulkWrite, err)
-		log.Fatalf("error: %v", err)
+		return fail(app.NewScopeError(app.AppBulkWrite, app.ExitStorage, err))
 	}
-	log.Printf("upsert: MatchedCount=%d, UpsertedCount=%d\n", result.MatchedCount, result.UpsertedCount)
-	log.Printf("total symbols: %d\n", len(*symbols))
+	log.Printf("upsert: MatchedCount=%d, UpsertedCount=%d, ModifiedCount=%d\n", result.MatchedCount, result.UpsertedCount, result.ModifiedCount)
+	log.Printf("total symbols: %d\n", len(symbols))
 
-	log.Printf("Start dumping klines for %d symbols, this might take a while...\n", len(*symbols))
+	log.Printf("Start dumping klines for %d symbols, this might take a while...\n", len(symbols))
 	log.Printf("Progress report every %d seconds.", config.Binance.Progress.Interval)
-	klinesCount := 0
-	matchedCount := int64(0)
-	upsertedCount := int64(0)
-
 	progressCtx, progressCancel := context.WithCancel(context.Background())
 	go func(ctx context.Context) {
 		ticker := time.NewTicker(time.Duration(config.Binance.Progress.Interval) * time.Second)
@@ -135,51 +288,67 @@ func main() {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				log.Printf("upsert: MatchedCount=%d, UpsertedCount=%d\n", matchedCount, upsertedCount)
+				logKlines()
 			}
 		}
 	}(progressCtx)
 
-	for _, symbol := range *symbols {
-		var klines []services.BinanceKline = app.GetKlines(
-			ctx,
-			symbol,
-			services.BinanceKlineInterval(config.Binance.Klines.Interval),
-			config.Binance.Klines.Limit,
-		)
-		klines = app.KlinesWithoutUnclosedKline(klines)
-		klinesCount += len(klines)
-		// log.Printf("klines: %+v\n", klines)
-		// log.Printf("total klines: %d\n", len(klines))
-		var bulkWriteModels []mongo.WriteModel
-		for _, kline := range klines {
-			updateOne := mongo.NewUpdateOneModel()
-			updateOne.SetFilter(bson.M{
-				"symbol":   kline.Symbol,
-				"interval": kline.Interval,
-				"openTime": kline.OpenTime,
-			})
-			updateOne.SetUpdate(bson.M{
-				"$setOnInsert": kline,
-			})
-			updateOne.SetUpsert(true)
-			bulkWriteModels = append(bulkWriteModels, updateOne)
+	intervals := config.KlinesIntervals()
+	for _, ki := range intervals {
+		log.Printf("klines: interval=%s, limit=%d, since=%q, backfill=%v, incremental=%v\n", ki.Interval, ki.Limit, ki.Since, config.Binance.Klines.Backfill, config.Binance.Klines.Incremental)
+	}
+
+	log.Printf("klines: workers=%d, writeMode=%s\n", config.Binance.Klines.Workers, config.Storage.WriteMode)
+
+	var failedMu sync.Mutex
+	var failed []string
+	skipKlines := func(err error) bool {
+		// 418 and 429 abort the pool, going on from a banned or throttled IP
+		// would escalate the ban
+		if !config.Binance.Klines.ContinueOnError || !app.IsSymbolOnly(err) {
+			return false
 		}
-		// BulkWrite
-		result, err := mongoSvc.BulkWrite(ctx, config.Mongo.Binance.KlinesCollection, bulkWriteModels)
-		if err != nil {
-			app.NotifyError(ctx, app.AppBulkWrite, err)
-			log.Fatalf("error: %v", err)
+		log.Printf("error: %v, skipped", err)
+		app.NotifyError(storeCtx, app.ScopeOf(err), err)
+		failedMu.Lock()
+		failed = append(failed, err.Error())
+		failedMu.Unlock()
+		return true
+	}
+
+	jobs = len(symbols) * len(intervals)
+	jobsDone, err = app.DumpAllKlines(ctx, config, services.GetBinance(), sink, symbols, intervals, dumpKlines, skipKlines)
+	if flusher, ok := sink.(app.Flusher); ok {
+		if flushErr := flusher.Flush(storeCtx); flushErr != nil && err == nil {
+			err = app.NewScopeError(app.AppBulkWrite, app.ExitStorage, flushErr)
 		}
-		// log.Printf("%+v", result)
-		matchedCount += result.MatchedCount
-		upsertedCount += result.UpsertedCount
 	}
 	progressCancel()
 	elapsed := time.Since(start)
-	log.Printf("upsert: MatchedCount=%d, UpsertedCount=%d\n", matchedCount, upsertedCount)
+	logKlines()
 	log.Printf("total klines: %d\n", klinesCount)
-	app.NotifyOK(ctx, app.StatusDone)
+	if err != nil {
+		return fail(err)
+	}
+	if len(failed) > 0 {
+		log.Printf("failed symbol intervals: %d/%d\n", len(failed), jobs)
+		for _, f := range failed {
+			log.Printf("failed: %s\n", f)
+		}
+		app.NotifyPartial(storeCtx, app.PairdumpProgress{
+			Jobs:     jobs,
+			JobsDone: jobsDone,
+			Klines:   klinesCount,
+			Matched:  matchedCount,
+			Upserted: upsertedCount,
+			Modified: modifiedCount,
+		}, failed)
+		log.Printf("Process took %s", elapsed)
+		log.Println("Partial")
+		return app.ExitPartial
+	}
+	app.NotifyOK(storeCtx, app.StatusDone)
 	log.Printf("Process took %s", elapsed)
 	log.Println("Done")
+	return app.ExitOK
 }